@@ -0,0 +1,98 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Register condition types
+const (
+	ConditionUUIDAllocated     = "UUIDAllocated"
+	ConditionHardwarePublished = "HardwarePublished"
+	ConditionConfigServed      = "ConfigServed"
+	ConditionNodeJoined        = "NodeJoined"
+	ConditionNodeReady         = "NodeReady"
+	ConditionReady             = "Ready"
+)
+
+// Condition mirrors metav1.Condition, which is not available in the
+// apimachinery version used by the operator
+type Condition struct {
+	Type               string                 `json:"type"`
+	Status             metav1.ConditionStatus `json:"status"`
+	ObservedGeneration int64                  `json:"observedGeneration,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+	Reason             string                 `json:"reason"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// SetCondition adds or updates the condition of the same type. The
+// LastTransitionTime is only changed when the condition status changes
+func (s *RegisterStatus) SetCondition(conditionType string, status metav1.ConditionStatus, reason, message string) {
+	now := metav1.Now()
+	for i := range s.Conditions {
+		if s.Conditions[i].Type != conditionType {
+			continue
+		}
+		if s.Conditions[i].Status != status {
+			s.Conditions[i].LastTransitionTime = now
+		}
+		s.Conditions[i].Status = status
+		s.Conditions[i].Reason = reason
+		s.Conditions[i].Message = message
+		s.Conditions[i].ObservedGeneration = s.ObservedGeneration
+		return
+	}
+
+	s.Conditions = append(s.Conditions, Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: s.ObservedGeneration,
+		LastTransitionTime: now,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// GetCondition returns the condition of the given type, or nil if not present
+func (s *RegisterStatus) GetCondition(conditionType string) *Condition {
+	for i := range s.Conditions {
+		if s.Conditions[i].Type == conditionType {
+			return &s.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// IsConditionTrue checks if the condition of the given type has status True
+func (s *RegisterStatus) IsConditionTrue(conditionType string) bool {
+	condition := s.GetCondition(conditionType)
+	return condition != nil && condition.Status == metav1.ConditionTrue
+}
+
+// SetPhase updates the status phase and records when the phase was entered
+func (s *RegisterStatus) SetPhase(phase string) {
+	if s.Status == phase {
+		return
+	}
+	s.Status = phase
+	if s.PhaseTransitionTimes == nil {
+		s.PhaseTransitionTimes = make(map[string]metav1.Time)
+	}
+	s.PhaseTransitionTimes[phase] = metav1.Now()
+}
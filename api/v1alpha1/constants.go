@@ -4,5 +4,46 @@ const (
 	ConfigMapName        = "tinkconfig"
 	ConfigMapNamespace   = "harvester-operator"
 	DefaultConfigURLPort = "30880"
+	DefaultConfigTLSPort = "30443"
 	DefaultISOURL        = "https://releases.rancher.com/harvester/master/harvester-amd64.iso"
+	DefaultDisk          = "/dev/sda"
+)
+
+// Secret holding the cluster join token, which is maintained by rancher for
+// the local harvester cluster
+const (
+	JoinTokenSecretName      = "local-rke-state"
+	JoinTokenSecretNamespace = "fleet-local"
+	JoinTokenSecretKey       = "serverToken"
+)
+
+// MacAddressesAnnotation can be set on a Node to a comma separated list of the mac
+// addresses of the node, which are used to match the Node to a Register
+const MacAddressesAnnotation = "node.harvesterci.io/mac-addresses"
+
+// Register status phases
+const (
+	UIDGenerated  = "uidgenerated"
+	HWPushed      = "hardwarepushed"
+	NodeProcessed = "nodeprocessed"
+	// Failed is entered when the node does not become Ready within the provisioning timeout
+	Failed = "failed"
+	// Adopted is entered by Registers created for existing tink hardware, which is left
+	// untouched until a reprovision is requested
+	Adopted = "adopted"
+)
+
+// Reprovision node policies
+const (
+	ReprovisionNodeKeep   = "Keep"
+	ReprovisionNodeCordon = "Cordon"
+	ReprovisionNodeDrain  = "Drain"
+	ReprovisionNodeDelete = "Delete"
+)
+
+// Provisioning failure reasons
+const (
+	ReasonNeverPXEBooted  = "NeverPXEBooted"
+	ReasonNodeNeverJoined = "NodeNeverJoined"
+	ReasonNodeNeverReady  = "NodeNeverReady"
 )
@@ -25,46 +25,148 @@ const (
 	DefaultSlug = "harvester_1_0_0"
 )
 
+// SupportedSlugs are the harvester installer slugs understood by boots
+var SupportedSlugs = []string{"harvester_0_3_0", DefaultSlug}
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 // RegisterSpec defines the desired state of Register
 type RegisterSpec struct {
-	MacAddress          string            `json:"macAddress"`
-	Token               string            `json:"token"`
-	Nameservers         []string          `json:"nameServers,omitempty"`
-	Interface           string            `json:"interface,omitempty"`
-	Address             string            `json:"address,omitempty"`
-	Netmask             string            `json:"netmask,omitempty"`
-	Gateway             string            `json:"gateway,omitempty"`
-	PXEIsoURL           string            `json:"pxeIsoURL,omitempty"`
-	ImageURL            string            `json:"imageURL,omitempty"`
-	SSHAuthorizedKeys   []string          `json:"sshAuthorizedKeys,omitempty"`
-	Modules             []string          `json:"modules,omitempty"`
-	Sysctls             map[string]string `json:"sysctls,omitempty"`
-	NTPServers          []string          `json:"ntpServers,omitempty"`
-	DNSNameservers      []string          `json:"dnsNameservers,omitempty"`
-	Wifi                []installer.Wifi  `json:"wifi,omitempty"`
-	Password            string            `json:"password,omitempty"`
-	Environment         map[string]string `json:"environment,omitempty"`
-	Disk                string            `json:"disk,omitempty"`
-	Slug                string            `json:"slug,omitempty"`
-	KernelBootArguments string            `json:"kernelBootArguments,omitempty"`
+	MacAddress string `json:"macAddress"`
+	// Hostname is the hostname of the node, which defaults to the name of the Register
+	Hostname string `json:"hostname,omitempty"`
+	// Token is the cluster join token. TokenSecretRef takes precedence when specified,
+	// and the join token of the harvester cluster is used if neither is specified
+	Token             string              `json:"token,omitempty"`
+	TokenSecretRef    *SecretKeyReference `json:"tokenSecretRef,omitempty"`
+	Nameservers       []string            `json:"nameServers,omitempty"`
+	Interface         string              `json:"interface,omitempty"`
+	Address           string              `json:"address,omitempty"`
+	Netmask           string              `json:"netmask,omitempty"`
+	Gateway           string              `json:"gateway,omitempty"`
+	PXEIsoURL         string              `json:"pxeIsoURL,omitempty"`
+	ImageURL          string              `json:"imageURL,omitempty"`
+	SSHAuthorizedKeys []string            `json:"sshAuthorizedKeys,omitempty"`
+	Modules           []string            `json:"modules,omitempty"`
+	Sysctls           map[string]string   `json:"sysctls,omitempty"`
+	NTPServers        []string            `json:"ntpServers,omitempty"`
+	DNSNameservers    []string            `json:"dnsNameservers,omitempty"`
+	Wifi              []installer.Wifi    `json:"wifi,omitempty"`
+	// Password is the plain text or crypt hashed OS password. PasswordSecretRef
+	// takes precedence when specified
+	Password          string              `json:"password,omitempty"`
+	PasswordSecretRef *SecretKeyReference `json:"passwordSecretRef,omitempty"`
+	// AllowHostnamePassword allows the hostname to be used as the OS password when
+	// no password is specified
+	AllowHostnamePassword bool               `json:"allowHostnamePassword,omitempty"`
+	Environment           map[string]string  `json:"environment,omitempty"`
+	Disk                  string             `json:"disk,omitempty"`
+	Slug                  string             `json:"slug,omitempty"`
+	KernelBootArguments   string             `json:"kernelBootArguments,omitempty"`
+	ManagementNetwork     *ManagementNetwork `json:"managementNetwork,omitempty"`
+	// Networks are rendered as is in to the installer networks. If harvester-mgmt is
+	// not present it is generated from the other network fields in the spec
+	Networks map[string]Network `json:"networks,omitempty"`
+	// ProvisioningTimeout is how long the node has to become Ready after the hardware is
+	// pushed to tink, and overrides the operator provisioning timeout. Zero disables the timeout
+	ProvisioningTimeout *metav1.Duration `json:"provisioningTimeout,omitempty"`
+	// ReprovisionGeneration triggers a reinstall of the node when changed
+	ReprovisionGeneration int64 `json:"reprovisionGeneration,omitempty"`
+	// ReprovisionNodePolicy is what happens to the existing Node when the node is reinstalled
+	// +kubebuilder:validation:Enum=Keep;Cordon;Drain;Delete
+	ReprovisionNodePolicy string `json:"reprovisionNodePolicy,omitempty"`
+}
+
+// SecretKeyReference refers to a key in a Secret
+type SecretKeyReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+// Network defines a network to be configured by the installer, and mirrors installer.Network
+type Network struct {
+	Interfaces   []installer.NetworkInterface `json:"interfaces,omitempty"`
+	Method       string                       `json:"method"`
+	IP           string                       `json:"ip,omitempty"`
+	SubnetMask   string                       `json:"subnetMask,omitempty"`
+	Gateway      string                       `json:"gateway,omitempty"`
+	DefaultRoute bool                         `json:"defaultRoute,omitempty"`
+	BondOptions  map[string]string            `json:"bondOptions,omitempty"`
+	VlanID       int                          `json:"vlanId,omitempty"`
+	MTU          int                          `json:"mtu,omitempty"`
+}
+
+// ManagementNetwork defines the NICs and bond settings used for the harvester-mgmt network.
+// When specified it takes precedence over Interface and MacAddress, and MacAddress must be one of the Interfaces
+type ManagementNetwork struct {
+	Interfaces  []installer.NetworkInterface `json:"interfaces"`
+	BondMode    string                       `json:"bondMode,omitempty"`
+	BondOptions map[string]string            `json:"bondOptions,omitempty"`
 }
 
 // RegisterStatus defines the observed state of Register
 type RegisterStatus struct {
-	Message           string `json:"message"`
-	Status            string `json:"status"`
-	UUID              string `json:"uuid"`
-	HardwarePublished bool   `json:"hardwarePublished"`
-	NodeReady         bool   `json:"nodeReady"`
+	Message string `json:"message"`
+	// FailureReason explains why a Register is in the failed phase
+	FailureReason        string                 `json:"failureReason,omitempty"`
+	Status               string                 `json:"status"`
+	UUID                 string                 `json:"uuid"`
+	ObservedGeneration   int64                  `json:"observedGeneration,omitempty"`
+	Conditions           []Condition            `json:"conditions,omitempty"`
+	PhaseTransitionTimes map[string]metav1.Time `json:"phaseTransitionTimes,omitempty"`
+	ConfigFetch          *ConfigFetchStatus     `json:"configFetch,omitempty"`
+	// HardwareHash is the hash of the hardware last pushed to tink, and is used to detect spec changes
+	HardwareHash string `json:"hardwareHash,omitempty"`
+	// ConfigURLNonce is signed in to the config url, and is rotated to invalidate issued urls
+	ConfigURLNonce string `json:"configURLNonce,omitempty"`
+	// ConfigURLExpiry is when the config url expires, if the operator is configured with a ttl
+	ConfigURLExpiry *metav1.Time `json:"configURLExpiry,omitempty"`
+	// ReprovisionGeneration is the last spec.reprovisionGeneration acted on
+	ReprovisionGeneration int64 `json:"reprovisionGeneration,omitempty"`
+	// LastReprovisionTime is when the node was last reinstalled. Nodes are only processed once
+	// they become Ready after this time
+	LastReprovisionTime *metav1.Time `json:"lastReprovisionTime,omitempty"`
+	// ReprovisionedBootID is the boot id of the node when it was reprovisioned, so the node is not
+	// mistaken for the reinstalled node if its kubelet registers it again before it reboots
+	ReprovisionedBootID string `json:"reprovisionedBootID,omitempty"`
+	// NodeName is the name of the node matched to the Register once it has joined the cluster
+	NodeName string `json:"nodeName,omitempty"`
+	// Node is the observed state of the node once it has joined the cluster
+	Node *NodeStatus `json:"node,omitempty"`
+}
+
+// NodeStatus records the state of the kubernetes Node for a Register
+type NodeStatus struct {
+	Ready bool `json:"ready"`
+	// ReadySince is when the Ready condition of the node last became true
+	ReadySince       *metav1.Time `json:"readySince,omitempty"`
+	KubeletVersion   string       `json:"kubeletVersion,omitempty"`
+	HarvesterVersion string       `json:"harvesterVersion,omitempty"`
+}
+
+// ConfigFetchStatus records the requests made to the config server for a Register
+type ConfigFetchStatus struct {
+	Count          int         `json:"count"`
+	FirstFetchTime metav1.Time `json:"firstFetchTime"`
+	LastFetchTime  metav1.Time `json:"lastFetchTime"`
+	LastFetchIP    string      `json:"lastFetchIP"`
+	// ExpectedIP is the static address of the node, or the ip of the first fetch
+	ExpectedIP    string   `json:"expectedIP,omitempty"`
+	UnexpectedIPs []string `json:"unexpectedIPs,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:resource:scope="Cluster"
+// +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Status",type="string",JSONPath=`.status.status`
 // +kubebuilder:printcolumn:name="UUID",type="string",JSONPath=`.status.uuid`
+// +kubebuilder:printcolumn:name="Node",type="string",JSONPath=`.status.nodeName`
+// +kubebuilder:printcolumn:name="Ready",type="string",JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Kubelet",type="string",JSONPath=`.status.node.kubeletVersion`,priority=1
+// +kubebuilder:printcolumn:name="Harvester",type="string",JSONPath=`.status.node.harvesterVersion`,priority=1
+// +kubebuilder:printcolumn:name="Reason",type="string",JSONPath=`.status.failureReason`,priority=1
 
 // Register is the Schema for the registers API
 type Register struct {
@@ -75,6 +177,14 @@ type Register struct {
 	Status RegisterStatus `json:"status,omitempty"`
 }
 
+// Hostname returns the hostname of the node for the Register
+func (r *Register) Hostname() string {
+	if len(r.Spec.Hostname) != 0 {
+		return r.Spec.Hostname
+	}
+	return r.Name
+}
+
 // +kubebuilder:object:root=true
 
 // RegisterList contains a list of Register
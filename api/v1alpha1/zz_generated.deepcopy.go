@@ -22,9 +22,48 @@ package v1alpha1
 
 import (
 	"github.com/ibrokethecloud/harvester-tink-operator/pkg/installer"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Condition) DeepCopyInto(out *Condition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Condition.
+func (in *Condition) DeepCopy() *Condition {
+	if in == nil {
+		return nil
+	}
+	out := new(Condition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ConfigFetchStatus) DeepCopyInto(out *ConfigFetchStatus) {
+	*out = *in
+	in.FirstFetchTime.DeepCopyInto(&out.FirstFetchTime)
+	in.LastFetchTime.DeepCopyInto(&out.LastFetchTime)
+	if in.UnexpectedIPs != nil {
+		in, out := &in.UnexpectedIPs, &out.UnexpectedIPs
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ConfigFetchStatus.
+func (in *ConfigFetchStatus) DeepCopy() *ConfigFetchStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(ConfigFetchStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Facility) DeepCopyInto(out *Facility) {
 	*out = *in
@@ -56,6 +95,33 @@ func (in *Instance) DeepCopy() *Instance {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManagementNetwork) DeepCopyInto(out *ManagementNetwork) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]installer.NetworkInterface, len(*in))
+		copy(*out, *in)
+	}
+	if in.BondOptions != nil {
+		in, out := &in.BondOptions, &out.BondOptions
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManagementNetwork.
+func (in *ManagementNetwork) DeepCopy() *ManagementNetwork {
+	if in == nil {
+		return nil
+	}
+	out := new(ManagementNetwork)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetaData) DeepCopyInto(out *MetaData) {
 	*out = *in
@@ -73,6 +139,52 @@ func (in *MetaData) DeepCopy() *MetaData {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Network) DeepCopyInto(out *Network) {
+	*out = *in
+	if in.Interfaces != nil {
+		in, out := &in.Interfaces, &out.Interfaces
+		*out = make([]installer.NetworkInterface, len(*in))
+		copy(*out, *in)
+	}
+	if in.BondOptions != nil {
+		in, out := &in.BondOptions, &out.BondOptions
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Network.
+func (in *Network) DeepCopy() *Network {
+	if in == nil {
+		return nil
+	}
+	out := new(Network)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeStatus) DeepCopyInto(out *NodeStatus) {
+	*out = *in
+	if in.ReadySince != nil {
+		in, out := &in.ReadySince, &out.ReadySince
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeStatus.
+func (in *NodeStatus) DeepCopy() *NodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *OperatingSystem) DeepCopyInto(out *OperatingSystem) {
 	*out = *in
@@ -94,7 +206,7 @@ func (in *Register) DeepCopyInto(out *Register) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Register.
@@ -150,6 +262,11 @@ func (in *RegisterList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegisterSpec) DeepCopyInto(out *RegisterSpec) {
 	*out = *in
+	if in.TokenSecretRef != nil {
+		in, out := &in.TokenSecretRef, &out.TokenSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
 	if in.Nameservers != nil {
 		in, out := &in.Nameservers, &out.Nameservers
 		*out = make([]string, len(*in))
@@ -187,6 +304,11 @@ func (in *RegisterSpec) DeepCopyInto(out *RegisterSpec) {
 		*out = make([]installer.Wifi, len(*in))
 		copy(*out, *in)
 	}
+	if in.PasswordSecretRef != nil {
+		in, out := &in.PasswordSecretRef, &out.PasswordSecretRef
+		*out = new(SecretKeyReference)
+		**out = **in
+	}
 	if in.Environment != nil {
 		in, out := &in.Environment, &out.Environment
 		*out = make(map[string]string, len(*in))
@@ -194,6 +316,23 @@ func (in *RegisterSpec) DeepCopyInto(out *RegisterSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.ManagementNetwork != nil {
+		in, out := &in.ManagementNetwork, &out.ManagementNetwork
+		*out = new(ManagementNetwork)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Networks != nil {
+		in, out := &in.Networks, &out.Networks
+		*out = make(map[string]Network, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ProvisioningTimeout != nil {
+		in, out := &in.ProvisioningTimeout, &out.ProvisioningTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegisterSpec.
@@ -209,6 +348,38 @@ func (in *RegisterSpec) DeepCopy() *RegisterSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RegisterStatus) DeepCopyInto(out *RegisterStatus) {
 	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.PhaseTransitionTimes != nil {
+		in, out := &in.PhaseTransitionTimes, &out.PhaseTransitionTimes
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.ConfigFetch != nil {
+		in, out := &in.ConfigFetch, &out.ConfigFetch
+		*out = new(ConfigFetchStatus)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ConfigURLExpiry != nil {
+		in, out := &in.ConfigURLExpiry, &out.ConfigURLExpiry
+		*out = (*in).DeepCopy()
+	}
+	if in.LastReprovisionTime != nil {
+		in, out := &in.LastReprovisionTime, &out.LastReprovisionTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Node != nil {
+		in, out := &in.Node, &out.Node
+		*out = new(NodeStatus)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RegisterStatus.
@@ -220,3 +391,18 @@ func (in *RegisterStatus) DeepCopy() *RegisterStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SecretKeyReference) DeepCopyInto(out *SecretKeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SecretKeyReference.
+func (in *SecretKeyReference) DeepCopy() *SecretKeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(SecretKeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,225 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"io"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/metrics"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/tink"
+	"github.com/pkg/errors"
+	"github.com/tinkerbell/tink/protos/hardware"
+	"k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// HardwareSync periodically reconciles the hardware in tink against the Register objects,
+// recreating missing hardware, correcting drifted hardware and reporting orphaned hardware
+type HardwareSync struct {
+	*RegisterReconciler
+	Interval time.Duration
+	// GCOrphans deletes hardware in tink which has no matching Register
+	GCOrphans bool
+	// AdoptOrphans creates Registers for hardware in tink which has no matching Register,
+	// and takes precedence over GCOrphans
+	AdoptOrphans bool
+}
+
+// Start implements manager.Runnable
+func (h *HardwareSync) Start(stop <-chan struct{}) error {
+	h.Log.Info("starting hardware sync", "interval", h.Interval.String(), "gcOrphans", h.GCOrphans, "adoptOrphans", h.AdoptOrphans)
+	wait.Until(func() {
+		if err := h.sync(context.Background()); err != nil {
+			h.Log.Error(err, "error during hardware sync")
+		}
+	}, h.Interval, stop)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (h *HardwareSync) NeedLeaderElection() bool {
+	return true
+}
+
+func (h *HardwareSync) sync(ctx context.Context) error {
+	hwList, err := h.listHardware(ctx)
+	if err != nil {
+		return err
+	}
+
+	registerList := &nodev1alpha1.RegisterList{}
+	if err := h.List(ctx, registerList); err != nil {
+		return errors.Wrap(err, "error listing registers")
+	}
+
+	registered := make(map[string]bool)
+	for i := range registerList.Items {
+		regoReq := &registerList.Items[i]
+		uuid := regoReq.Status.UUID
+		if len(uuid) == 0 {
+			uuid = regoReq.Labels["uuid"]
+		}
+		registered[uuid] = true
+
+		if !regoReq.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		switch regoReq.Status.Status {
+		case HWPushed, NodeProcessed, nodev1alpha1.Failed:
+		default:
+			// hardware is yet to be pushed by the reconciler
+			continue
+		}
+
+		if err := h.syncRegister(ctx, regoReq, hwList[uuid]); err != nil {
+			h.Log.Error(err, "error syncing hardware", "register", regoReq.Name)
+		}
+	}
+
+	orphans := 0
+	for id, hw := range hwList {
+		if registered[id] {
+			continue
+		}
+		orphans++
+
+		if h.AdoptOrphans {
+			if err := h.adoptHardware(ctx, hw); err != nil {
+				h.Log.Error(err, "error adopting hardware", "uuid", id)
+			}
+			continue
+		}
+
+		if !h.GCOrphans {
+			h.Recorder.Eventf(tinkConfigRef(), v1.EventTypeWarning, "OrphanedHardware",
+				"hardware %s (%s) has no matching register", id, hardwareMacs(hw))
+			continue
+		}
+
+		if err := h.deleteHardware(ctx, id); err != nil {
+			h.Log.Error(err, "error deleting orphaned hardware", "uuid", id)
+			continue
+		}
+		h.Recorder.Eventf(tinkConfigRef(), v1.EventTypeNormal, "OrphanedHardwareDeleted",
+			"hardware %s (%s) with no matching register deleted", id, hardwareMacs(hw))
+	}
+	metrics.SetOrphanedHardware(orphans)
+
+	return nil
+}
+
+// syncRegister recreates or corrects the hardware for a single register //
+func (h *HardwareSync) syncRegister(ctx context.Context, regoReq *nodev1alpha1.Register, current *hardware.Hardware) error {
+	desired, _, err := h.renderHardware(regoReq, &regoReq.Status)
+	if err != nil {
+		return err
+	}
+
+	if tink.HardwareEqual(desired, current) {
+		return nil
+	}
+
+	if err := h.pushHardware(ctx, desired); err != nil {
+		h.Recorder.Eventf(regoReq, v1.EventTypeWarning, "HardwarePushFailed", "%v", err)
+		return err
+	}
+
+	if current == nil {
+		h.Recorder.Eventf(regoReq, v1.EventTypeNormal, "HardwareRecreated", "hardware %s was missing in tink and has been recreated", desired.Id)
+	} else {
+		h.Recorder.Eventf(regoReq, v1.EventTypeNormal, "HardwareDriftCorrected", "hardware %s was modified in tink and has been corrected", desired.Id)
+	}
+	return nil
+}
+
+// adoptHardware creates a Register for existing tink hardware, reusing the tink id //
+func (h *HardwareSync) adoptHardware(ctx context.Context, hw *hardware.Hardware) error {
+	regoReq, err := tink.RegisterFromHardware(hw)
+	if err != nil {
+		return err
+	}
+
+	existing := &nodev1alpha1.Register{}
+	err = h.Get(ctx, types.NamespacedName{Name: regoReq.Name}, existing)
+	if err == nil {
+		h.Recorder.Eventf(tinkConfigRef(), v1.EventTypeWarning, "HardwareAdoptionFailed",
+			"hardware %s can not be adopted as register %s already exists", hw.Id, regoReq.Name)
+		return nil
+	}
+	if !apierror.IsNotFound(err) {
+		return err
+	}
+
+	if err := h.Create(ctx, regoReq); err != nil {
+		h.Recorder.Eventf(tinkConfigRef(), v1.EventTypeWarning, "HardwareAdoptionFailed",
+			"error creating register %s for hardware %s: %v", regoReq.Name, hw.Id, err)
+		return err
+	}
+
+	h.Recorder.Eventf(regoReq, v1.EventTypeNormal, "HardwareAdopted", "register created for existing hardware %s", hw.Id)
+	return nil
+}
+
+func (h *HardwareSync) listHardware(ctx context.Context) (hwList map[string]*hardware.Hardware, err error) {
+	hardwareClient, err := h.Tink.HardwareClient()
+	if err != nil {
+		return nil, err
+	}
+
+	allClient, err := hardwareClient.All(ctx, &hardware.Empty{})
+	if err != nil {
+		return nil, errors.Wrap(err, "error listing hardware")
+	}
+
+	hwList = make(map[string]*hardware.Hardware)
+	for {
+		hw, err := allClient.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "error receiving hardware")
+		}
+		hwList[hw.Id] = hw
+	}
+
+	return hwList, nil
+}
+
+// tinkConfigRef is used to attach events which are not specific to a register //
+func tinkConfigRef() *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:       "ConfigMap",
+		APIVersion: "v1",
+		Name:       config.Get().TinkConfigMapName,
+		Namespace:  config.Get().TinkConfigMapNamespace,
+	}
+}
+
+func hardwareMacs(hw *hardware.Hardware) (macs []string) {
+	for _, nic := range hw.GetNetwork().GetInterfaces() {
+		macs = append(macs, nic.GetDhcp().GetMac())
+	}
+	return macs
+}
@@ -0,0 +1,68 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// reconcileTracker records the reconciles in progress, so a reconcile which never
+// returns, such as one blocked on a tink call, fails the liveness check
+type reconcileTracker struct {
+	mu       sync.Mutex
+	inFlight map[string]time.Time
+}
+
+// track records the start of a reconcile, and returns a func to call once it is done
+func (t *reconcileTracker) track(name string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.inFlight == nil {
+		t.inFlight = make(map[string]time.Time)
+	}
+	t.inFlight[name] = time.Now()
+
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		delete(t.inFlight, name)
+	}
+}
+
+// check fails if any reconcile has been running for longer than the timeout
+func (t *reconcileTracker) check(timeout time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for name, started := range t.inFlight {
+		if elapsed := time.Since(started); elapsed > timeout {
+			return fmt.Errorf("reconcile of register %s has been running for %s", name, elapsed.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// LivenessCheck implements healthz.Checker, and fails when a reconcile is stuck
+func (r *RegisterReconciler) LivenessCheck(_ *http.Request) error {
+	timeout := r.ReconcileTimeout
+	if timeout == 0 {
+		timeout = defaultReconcileTimeout
+	}
+	return r.tracker.check(timeout)
+}
@@ -0,0 +1,218 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/metrics"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// harvesterOSImagePrefix prefixes the version in the os image reported by harvester nodes
+const harvesterOSImagePrefix = "Harvester "
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+
+// observeNode generates the node status for a Register from the Ready condition and node info of a Node
+func observeNode(node *v1.Node) *nodev1alpha1.NodeStatus {
+	nodeStatus := &nodev1alpha1.NodeStatus{
+		KubeletVersion:   node.Status.NodeInfo.KubeletVersion,
+		HarvesterVersion: harvesterVersion(node),
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type != v1.NodeReady {
+			continue
+		}
+		if condition.Status == v1.ConditionTrue {
+			readySince := condition.LastTransitionTime
+			nodeStatus.Ready = true
+			nodeStatus.ReadySince = &readySince
+		}
+	}
+
+	return nodeStatus
+}
+
+// harvesterVersion extracts the harvester version from the os image of a node, which is
+// reported as "Harvester <version>" by harvester nodes
+func harvesterVersion(node *v1.Node) string {
+	osImage := node.Status.NodeInfo.OSImage
+	if !strings.HasPrefix(osImage, harvesterOSImagePrefix) {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(osImage, harvesterOSImagePrefix))
+}
+
+// nodeStable checks if the node has been Ready for the stabilization period, and if not
+// returns how much longer a Ready node has to stay Ready. When reprovisioned, the node must
+// have become Ready after it was reprovisioned, so the old install is not mistaken for the new one
+func nodeStable(nodeStatus *nodev1alpha1.NodeStatus, period time.Duration, reprovisioned *metav1.Time) (stable bool, remaining time.Duration) {
+	if !nodeStatus.Ready || nodeStatus.ReadySince == nil {
+		return false, period
+	}
+
+	if reprovisioned != nil && nodeStatus.ReadySince.Before(reprovisioned) {
+		return false, period
+	}
+
+	remaining = period - time.Since(nodeStatus.ReadySince.Time)
+	return remaining <= 0, remaining
+}
+
+// nodeJoined checks if a node found for a register is the installed node. After a reprovision the
+// node from the previous install may still be found until the machine reboots, so the node must
+// have been created or become Ready after the reprovision, and have rebooted since
+func nodeJoined(node *v1.Node, regoStatus *nodev1alpha1.RegisterStatus) bool {
+	reprovisioned := regoStatus.LastReprovisionTime
+	if reprovisioned == nil {
+		return true
+	}
+
+	bootID := regoStatus.ReprovisionedBootID
+	if len(bootID) != 0 && node.Status.NodeInfo.BootID == bootID {
+		return false
+	}
+
+	if !node.CreationTimestamp.Before(reprovisioned) {
+		return true
+	}
+
+	readySince := observeNode(node).ReadySince
+	return readySince != nil && !readySince.Before(reprovisioned)
+}
+
+// setNodeStatus records the observed node in the register status, and updates the NodeReady condition
+func setNodeStatus(regoStatus *nodev1alpha1.RegisterStatus, nodeName string, nodeStatus *nodev1alpha1.NodeStatus) {
+	regoStatus.Node = nodeStatus
+	if nodeStatus == nil {
+		regoStatus.SetCondition(nodev1alpha1.ConditionNodeReady, metav1.ConditionFalse, "NodeNotFound", "node "+nodeName+" not found")
+		return
+	}
+
+	if nodeStatus.Ready {
+		regoStatus.SetCondition(nodev1alpha1.ConditionNodeReady, metav1.ConditionTrue, "KubeletReady", "")
+	} else {
+		regoStatus.SetCondition(nodev1alpha1.ConditionNodeReady, metav1.ConditionFalse, "KubeletNotReady", "node "+nodeName+" is not ready")
+	}
+}
+
+// matchNode checks if a Node belongs to a Register, and returns how it was matched. The installer
+// may not end up using the requested hostname, so nodes are also matched by the system uuid,
+// the mac addresses in the MacAddressesAnnotation, or the static address. The ip the config was
+// fetched from is not used, as it may be the address of a proxy or another node
+func matchNode(regoReq *nodev1alpha1.Register, node *v1.Node) (reason string) {
+	if node.Name == regoReq.Hostname() {
+		return "Hostname"
+	}
+
+	if len(regoReq.Status.UUID) != 0 && strings.EqualFold(node.Status.NodeInfo.SystemUUID, regoReq.Status.UUID) {
+		return "SystemUUID"
+	}
+
+	if macs, err := util.HardwareAddrs(&regoReq.Spec); err == nil {
+		for _, addr := range strings.Split(node.Annotations[nodev1alpha1.MacAddressesAnnotation], ",") {
+			hwAddr, err := net.ParseMAC(strings.TrimSpace(addr))
+			if err == nil && macs[hwAddr.String()] {
+				return "MacAddress"
+			}
+		}
+	}
+
+	if len(regoReq.Spec.Address) == 0 {
+		return ""
+	}
+	for _, address := range node.Status.Addresses {
+		if address.Type != v1.NodeInternalIP && address.Type != v1.NodeExternalIP {
+			continue
+		}
+		if address.Address == regoReq.Spec.Address {
+			return "Address"
+		}
+	}
+
+	return ""
+}
+
+const (
+	// minNodeWaitInterval and maxNodeWaitInterval bound the requeue interval while waiting for a node
+	minNodeWaitInterval = 30 * time.Second
+	maxNodeWaitInterval = 5 * time.Minute
+)
+
+// waitForNode fails the register once the provisioning timeout has passed, else returns when to
+// check on the node again. The interval backs off as the wait grows, as the node watch also
+// requeues the register when a matching node joins or changes
+func (r *RegisterReconciler) waitForNode(regoReq *nodev1alpha1.Register, regoStatus *nodev1alpha1.RegisterStatus, nodeStatus *nodev1alpha1.NodeStatus, remaining time.Duration) ctrl.Result {
+	started := regoReq.CreationTimestamp
+	if pushed, ok := regoStatus.PhaseTransitionTimes[nodev1alpha1.HWPushed]; ok {
+		started = pushed
+	}
+	waited := time.Since(started.Time)
+
+	timeout := r.ProvisioningTimeout
+	if regoReq.Spec.ProvisioningTimeout != nil {
+		timeout = regoReq.Spec.ProvisioningTimeout.Duration
+	}
+
+	if timeout > 0 && waited >= timeout {
+		reason, message := provisioningFailure(regoStatus, nodeStatus, timeout)
+		regoStatus.SetPhase(nodev1alpha1.Failed)
+		regoStatus.FailureReason = reason
+		regoStatus.Message = message
+		regoStatus.SetCondition(nodev1alpha1.ConditionReady, metav1.ConditionFalse, reason, message)
+		r.Recorder.Event(regoReq, v1.EventTypeWarning, "ProvisioningFailed", message)
+		metrics.ObserveProvisioningFailure(reason)
+		return ctrl.Result{}
+	}
+
+	interval := waited / 4
+	if interval < minNodeWaitInterval {
+		interval = minNodeWaitInterval
+	}
+	if interval > maxNodeWaitInterval {
+		interval = maxNodeWaitInterval
+	}
+	if nodeStatus != nil && nodeStatus.Ready && remaining < interval {
+		interval = remaining
+	}
+	if timeout > 0 && timeout-waited < interval {
+		interval = timeout - waited
+	}
+	return ctrl.Result{RequeueAfter: interval}
+}
+
+// provisioningFailure explains how far a register got before timing out, based on whether the
+// config was fetched and whether the node joined
+func provisioningFailure(regoStatus *nodev1alpha1.RegisterStatus, nodeStatus *nodev1alpha1.NodeStatus, timeout time.Duration) (reason, message string) {
+	switch {
+	case nodeStatus != nil:
+		return nodev1alpha1.ReasonNodeNeverReady, fmt.Sprintf("node %s joined but was not ready for the stabilization period within %s", regoStatus.NodeName, timeout)
+	case regoStatus.ConfigFetch != nil:
+		return nodev1alpha1.ReasonNodeNeverJoined, fmt.Sprintf("config was fetched at %s but the node did not join within %s", regoStatus.ConfigFetch.LastFetchTime.UTC().Format(time.RFC3339), timeout)
+	default:
+		return nodev1alpha1.ReasonNeverPXEBooted, fmt.Sprintf("config was not fetched within %s, the node may not have pxe booted", timeout)
+	}
+}
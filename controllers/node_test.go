@@ -0,0 +1,431 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestMatchNode(t *testing.T) {
+	regoReq := &nodev1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+		Spec: nodev1alpha1.RegisterSpec{
+			MacAddress: "0c:c4:7a:6b:84:20",
+			Address:    "172.16.128.11",
+		},
+		Status: nodev1alpha1.RegisterStatus{
+			UUID: "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e",
+		},
+	}
+
+	tests := []struct {
+		name string
+		node *v1.Node
+		want string
+	}{
+		{
+			name: "hostname takes precedence",
+			node: testNode("node1", "0C4A6E5E-3F5B-4A47-8C0E-6A6B1C6C1A2E", "0c:c4:7a:6b:84:20", "172.16.128.11"),
+			want: "Hostname",
+		},
+		{
+			name: "system uuid before mac address",
+			node: testNode("harvester-abcde", "0C4A6E5E-3F5B-4A47-8C0E-6A6B1C6C1A2E", "0c:c4:7a:6b:84:20", "172.16.128.11"),
+			want: "SystemUUID",
+		},
+		{
+			name: "mac address before address",
+			node: testNode("harvester-abcde", "", "0a:0b:0c:0d:0e:0f, 0C-C4-7A-6B-84-20", "172.16.128.11"),
+			want: "MacAddress",
+		},
+		{
+			name: "address",
+			node: testNode("harvester-abcde", "", "", "172.16.128.11"),
+			want: "Address",
+		},
+		{
+			name: "no match",
+			node: testNode("harvester-abcde", "f2a3e1a4-7f0c-4f6b-9a64-0d1a7e0c2b57", "0a:0b:0c:0d:0e:0f", "172.16.128.12"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchNode(regoReq, tt.node); got != tt.want {
+				t.Errorf("matchNode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("spec hostname", func(t *testing.T) {
+		renamed := regoReq.DeepCopy()
+		renamed.Spec.Hostname = "harvester-abcde"
+		if got := matchNode(renamed, testNode("harvester-abcde", "", "", "")); got != "Hostname" {
+			t.Errorf("matchNode() = %q, want %q", got, "Hostname")
+		}
+	})
+
+	t.Run("no address", func(t *testing.T) {
+		dhcp := regoReq.DeepCopy()
+		dhcp.Spec.Address = ""
+		if got := matchNode(dhcp, testNode("harvester-abcde", "", "", "")); got != "" {
+			t.Errorf("matchNode() = %q, want no match", got)
+		}
+	})
+}
+
+// testNode generates a node with the system uuid, mac addresses annotation and internal ip set when not empty
+func testNode(name, systemUUID, macs, address string) *v1.Node {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+	}
+	node.Status.NodeInfo.SystemUUID = systemUUID
+	if len(macs) != 0 {
+		node.Annotations = map[string]string{nodev1alpha1.MacAddressesAnnotation: macs}
+	}
+	if len(address) != 0 {
+		node.Status.Addresses = []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: address}}
+	}
+	return node
+}
+
+func TestNodeJoined(t *testing.T) {
+	ago := func(d time.Duration) metav1.Time {
+		return metav1.NewTime(time.Now().Add(-d))
+	}
+	reprovisioned := ago(30 * time.Minute)
+
+	// node generates a node created and last Ready at the times passed, with the boot id passed
+	node := func(created, readySince metav1.Time, bootID string) *v1.Node {
+		return &v1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node1", CreationTimestamp: created},
+			Status: v1.NodeStatus{
+				NodeInfo: v1.NodeSystemInfo{BootID: bootID},
+				Conditions: []v1.NodeCondition{
+					{Type: v1.NodeReady, Status: v1.ConditionTrue, LastTransitionTime: readySince},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		node       *v1.Node
+		regoStatus *nodev1alpha1.RegisterStatus
+		want       bool
+	}{
+		{
+			name:       "never reprovisioned",
+			node:       node(ago(time.Hour), ago(time.Hour), "boot-1"),
+			regoStatus: &nodev1alpha1.RegisterStatus{},
+			want:       true,
+		},
+		{
+			name:       "node from before the reprovision",
+			node:       node(ago(time.Hour), ago(time.Hour), "boot-1"),
+			regoStatus: &nodev1alpha1.RegisterStatus{LastReprovisionTime: &reprovisioned, ReprovisionedBootID: "boot-1"},
+		},
+		{
+			name:       "node from before the reprovision which was not found",
+			node:       node(ago(time.Hour), ago(time.Hour), "boot-1"),
+			regoStatus: &nodev1alpha1.RegisterStatus{LastReprovisionTime: &reprovisioned},
+		},
+		{
+			name:       "deleted node registered again before the reboot",
+			node:       node(ago(time.Minute), ago(time.Minute), "boot-1"),
+			regoStatus: &nodev1alpha1.RegisterStatus{LastReprovisionTime: &reprovisioned, ReprovisionedBootID: "boot-1"},
+		},
+		{
+			name:       "reinstalled node ready again",
+			node:       node(ago(time.Hour), ago(time.Minute), "boot-2"),
+			regoStatus: &nodev1alpha1.RegisterStatus{LastReprovisionTime: &reprovisioned, ReprovisionedBootID: "boot-1"},
+			want:       true,
+		},
+		{
+			name:       "reinstalled node created again",
+			node:       node(ago(time.Minute), ago(time.Minute), "boot-2"),
+			regoStatus: &nodev1alpha1.RegisterStatus{LastReprovisionTime: &reprovisioned, ReprovisionedBootID: "boot-1"},
+			want:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nodeJoined(tt.node, tt.regoStatus); got != tt.want {
+				t.Errorf("nodeJoined() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("reinstalled node not ready", func(t *testing.T) {
+		reinstalled := node(ago(time.Hour), ago(time.Hour), "boot-2")
+		reinstalled.Status.Conditions[0].Status = v1.ConditionFalse
+		reinstalled.Status.Conditions[0].LastTransitionTime = ago(time.Minute)
+		regoStatus := &nodev1alpha1.RegisterStatus{LastReprovisionTime: &reprovisioned, ReprovisionedBootID: "boot-1"}
+		if nodeJoined(reinstalled, regoStatus) {
+			t.Errorf("nodeJoined() = true for a node which has not been Ready since the reprovision, want false")
+		}
+	})
+}
+
+func TestNodeStable(t *testing.T) {
+	period := 5 * time.Minute
+	readySince := func(ago time.Duration) *metav1.Time {
+		since := metav1.NewTime(time.Now().Add(-ago))
+		return &since
+	}
+
+	tests := []struct {
+		name          string
+		nodeStatus    *nodev1alpha1.NodeStatus
+		reprovisioned *metav1.Time
+		wantStable    bool
+		// wantRemaining is the expected remaining time, to within a second
+		wantRemaining time.Duration
+	}{
+		{
+			name:          "not ready",
+			nodeStatus:    &nodev1alpha1.NodeStatus{},
+			wantRemaining: period,
+		},
+		{
+			name:          "ready without a transition time",
+			nodeStatus:    &nodev1alpha1.NodeStatus{Ready: true},
+			wantRemaining: period,
+		},
+		{
+			name:          "ready within the stabilization period",
+			nodeStatus:    &nodev1alpha1.NodeStatus{Ready: true, ReadySince: readySince(time.Minute)},
+			wantRemaining: 4 * time.Minute,
+		},
+		{
+			name:          "ready for the stabilization period",
+			nodeStatus:    &nodev1alpha1.NodeStatus{Ready: true, ReadySince: readySince(10 * time.Minute)},
+			wantStable:    true,
+			wantRemaining: -5 * time.Minute,
+		},
+		{
+			name:          "ready before the reprovision",
+			nodeStatus:    &nodev1alpha1.NodeStatus{Ready: true, ReadySince: readySince(time.Hour)},
+			reprovisioned: readySince(30 * time.Minute),
+			wantRemaining: period,
+		},
+		{
+			name:          "ready after the reprovision within the stabilization period",
+			nodeStatus:    &nodev1alpha1.NodeStatus{Ready: true, ReadySince: readySince(2 * time.Minute)},
+			reprovisioned: readySince(30 * time.Minute),
+			wantRemaining: 3 * time.Minute,
+		},
+		{
+			name:          "ready after the reprovision for the stabilization period",
+			nodeStatus:    &nodev1alpha1.NodeStatus{Ready: true, ReadySince: readySince(10 * time.Minute)},
+			reprovisioned: readySince(30 * time.Minute),
+			wantStable:    true,
+			wantRemaining: -5 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stable, remaining := nodeStable(tt.nodeStatus, period, tt.reprovisioned)
+			if stable != tt.wantStable {
+				t.Errorf("nodeStable() stable = %v, want %v", stable, tt.wantStable)
+			}
+			if !durationNear(remaining, tt.wantRemaining) {
+				t.Errorf("nodeStable() remaining = %s, want %s", remaining, tt.wantRemaining)
+			}
+		})
+	}
+
+	t.Run("no stabilization period", func(t *testing.T) {
+		stable, _ := nodeStable(&nodev1alpha1.NodeStatus{Ready: true, ReadySince: readySince(0)}, 0, nil)
+		if !stable {
+			t.Errorf("nodeStable() stable = false, want true")
+		}
+		if stable, _ := nodeStable(&nodev1alpha1.NodeStatus{}, 0, nil); stable {
+			t.Errorf("nodeStable() stable = true for a node which is not ready, want false")
+		}
+	})
+}
+
+func TestWaitForNode(t *testing.T) {
+	tests := []struct {
+		name string
+		// waited is how long ago the hardware was pushed
+		waited      time.Duration
+		timeout     time.Duration
+		specTimeout *metav1.Duration
+		nodeStatus  *nodev1alpha1.NodeStatus
+		remaining   time.Duration
+		// wantInterval is the expected requeue interval, to within a second
+		wantInterval time.Duration
+		wantFailed   bool
+	}{
+		{
+			name:         "minimum interval",
+			wantInterval: minNodeWaitInterval,
+		},
+		{
+			name:         "backs off as the wait grows",
+			waited:       4 * time.Minute,
+			wantInterval: time.Minute,
+		},
+		{
+			name:         "maximum interval",
+			waited:       time.Hour,
+			wantInterval: maxNodeWaitInterval,
+		},
+		{
+			name:         "capped by the stabilization period remaining",
+			waited:       time.Hour,
+			nodeStatus:   &nodev1alpha1.NodeStatus{Ready: true},
+			remaining:    10 * time.Second,
+			wantInterval: 10 * time.Second,
+		},
+		{
+			name:         "stabilization period ignored for a node which is not ready",
+			waited:       time.Hour,
+			nodeStatus:   &nodev1alpha1.NodeStatus{},
+			remaining:    10 * time.Second,
+			wantInterval: maxNodeWaitInterval,
+		},
+		{
+			name:         "capped by the timeout",
+			waited:       time.Hour,
+			timeout:      time.Hour + 20*time.Second,
+			wantInterval: 20 * time.Second,
+		},
+		{
+			name:       "timed out",
+			waited:     time.Hour,
+			timeout:    time.Hour,
+			wantFailed: true,
+		},
+		{
+			name:         "spec timeout overrides the default",
+			waited:       time.Hour,
+			timeout:      30 * time.Minute,
+			specTimeout:  &metav1.Duration{Duration: 2 * time.Hour},
+			wantInterval: maxNodeWaitInterval,
+		},
+		{
+			name:        "spec timeout disables the default",
+			waited:      time.Hour,
+			timeout:     30 * time.Minute,
+			specTimeout: &metav1.Duration{},
+			// the default timeout of 30m would have failed the register
+			wantInterval: maxNodeWaitInterval,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &RegisterReconciler{
+				Recorder:            recorder,
+				ProvisioningTimeout: tt.timeout,
+			}
+
+			regoReq := &nodev1alpha1.Register{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       nodev1alpha1.RegisterSpec{ProvisioningTimeout: tt.specTimeout},
+			}
+			regoStatus := &nodev1alpha1.RegisterStatus{
+				Status: HWPushed,
+				PhaseTransitionTimes: map[string]metav1.Time{
+					HWPushed: metav1.NewTime(time.Now().Add(-tt.waited)),
+				},
+			}
+
+			result := r.waitForNode(regoReq, regoStatus, tt.nodeStatus, tt.remaining)
+			if tt.wantFailed {
+				if regoStatus.Status != Failed || len(regoStatus.FailureReason) == 0 {
+					t.Errorf("waitForNode() phase = %s, reason = %s, want failed", regoStatus.Status, regoStatus.FailureReason)
+				}
+				if result.RequeueAfter != 0 {
+					t.Errorf("waitForNode() requeueAfter = %s for a failed register, want 0", result.RequeueAfter)
+				}
+				if len(recorder.Events) != 1 {
+					t.Errorf("waitForNode() recorded %d events, want 1", len(recorder.Events))
+				}
+				return
+			}
+
+			if regoStatus.Status != HWPushed {
+				t.Errorf("waitForNode() phase = %s, want %s", regoStatus.Status, HWPushed)
+			}
+			if !durationNear(result.RequeueAfter, tt.wantInterval) {
+				t.Errorf("waitForNode() requeueAfter = %s, want %s", result.RequeueAfter, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestProvisioningFailure(t *testing.T) {
+	tests := []struct {
+		name       string
+		regoStatus *nodev1alpha1.RegisterStatus
+		nodeStatus *nodev1alpha1.NodeStatus
+		want       string
+	}{
+		{
+			name:       "config never fetched",
+			regoStatus: &nodev1alpha1.RegisterStatus{},
+			want:       nodev1alpha1.ReasonNeverPXEBooted,
+		},
+		{
+			name: "config fetched but the node never joined",
+			regoStatus: &nodev1alpha1.RegisterStatus{
+				ConfigFetch: &nodev1alpha1.ConfigFetchStatus{Count: 1, LastFetchTime: metav1.Now()},
+			},
+			want: nodev1alpha1.ReasonNodeNeverJoined,
+		},
+		{
+			name: "node joined but never ready",
+			regoStatus: &nodev1alpha1.RegisterStatus{
+				NodeName:    "node1",
+				ConfigFetch: &nodev1alpha1.ConfigFetchStatus{Count: 1, LastFetchTime: metav1.Now()},
+			},
+			nodeStatus: &nodev1alpha1.NodeStatus{},
+			want:       nodev1alpha1.ReasonNodeNeverReady,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, message := provisioningFailure(tt.regoStatus, tt.nodeStatus, time.Hour)
+			if reason != tt.want {
+				t.Errorf("provisioningFailure() reason = %s, want %s", reason, tt.want)
+			}
+			if len(message) == 0 {
+				t.Errorf("provisioningFailure() message is empty")
+			}
+		})
+	}
+}
+
+// durationNear allows for the time passed while a test runs
+func durationNear(got, want time.Duration) bool {
+	diff := got - want
+	return diff > -time.Second && diff < time.Second
+}
@@ -0,0 +1,99 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"reflect"
+
+	"github.com/go-logr/logr"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
+// OperatorConfigWatcher reloads the operator config when the operator configmap changes.
+// Invalid configmaps are reported via events, and the previous config is kept. Only the
+// operator configmap is watched, rather than caching every configmap in the cluster
+type OperatorConfigWatcher struct {
+	// APIReader reads the configmap, and KubeClient watches it
+	APIReader  client.Reader
+	KubeClient kubernetes.Interface
+	Log        logr.Logger
+	Recorder   record.EventRecorder
+	Base       *config.Config
+	Name       string
+	Namespace  string
+}
+
+// Start implements manager.Runnable
+func (w *OperatorConfigWatcher) Start(stop <-chan struct{}) error {
+	listWatch := toolscache.NewListWatchFromClient(w.KubeClient.CoreV1().RESTClient(), "configmaps",
+		w.Namespace, fields.OneTermEqualSelector("metadata.name", w.Name))
+	informer := toolscache.NewSharedInformer(listWatch, &v1.ConfigMap{}, 0)
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			w.reload()
+		},
+		UpdateFunc: func(_, _ interface{}) {
+			w.reload()
+		},
+		DeleteFunc: func(interface{}) {
+			w.reload()
+		},
+	})
+
+	informer.Run(stop)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The config
+// is also used by the config server and webhooks, which run on all replicas
+func (w *OperatorConfigWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *OperatorConfigWatcher) reload() {
+	cfg, err := config.Load(w.APIReader, w.Base, w.Name, w.Namespace)
+	if err != nil {
+		w.Log.Error(err, "unable to reload operator config, keeping previous config")
+		w.Recorder.Eventf(w.configMapRef(), v1.EventTypeWarning, "InvalidOperatorConfig", "%v", err)
+		return
+	}
+
+	if reflect.DeepEqual(cfg, config.Get()) {
+		return
+	}
+
+	config.Set(cfg)
+	w.Log.Info("operator config reloaded", "config", cfg)
+	w.Recorder.Event(w.configMapRef(), v1.EventTypeNormal, "OperatorConfigReloaded", "operator config reloaded")
+}
+
+func (w *OperatorConfigWatcher) configMapRef() *v1.ObjectReference {
+	return &v1.ObjectReference{
+		Kind:       "ConfigMap",
+		APIVersion: "v1",
+		Name:       w.Name,
+		Namespace:  w.Namespace,
+	}
+}
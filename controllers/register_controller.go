@@ -19,15 +19,21 @@ package controllers
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"k8s.io/apimachinery/pkg/types"
+	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 	"strings"
+	"time"
 
 	"github.com/tinkerbell/tink/protos/hardware"
 
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/configurl"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/metrics"
 	"github.com/ibrokethecloud/harvester-tink-operator/pkg/tink"
 	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
 
@@ -35,10 +41,12 @@ import (
 	"github.com/google/uuid"
 	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
 	"github.com/pkg/errors"
-	hw "github.com/tinkerbell/tink/client"
 	"k8s.io/api/core/v1"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -46,25 +54,50 @@ import (
 
 const (
 	regoFinalizer = "register.harvesterci.io"
-	UIDGenerated  = "uidgenerated"
-	HWPushed      = "hardwarepushed"
-	NodeProcessed = "nodeprocessed"
+	// defaultReconcileTimeout is how long a reconcile can run before the liveness check fails
+	defaultReconcileTimeout = 5 * time.Minute
+	UIDGenerated            = nodev1alpha1.UIDGenerated
+	HWPushed                = nodev1alpha1.HWPushed
+	NodeProcessed           = nodev1alpha1.NodeProcessed
+	Failed                  = nodev1alpha1.Failed
+	Adopted                 = nodev1alpha1.Adopted
 )
 
 // RegisterReconciler reconciles a Register object
 type RegisterReconciler struct {
 	client.Client
+	// APIReader and KubeClient are used to list and evict pods when draining a node
+	APIReader  client.Reader
+	KubeClient kubernetes.Interface
 	Log        logr.Logger
 	Scheme     *runtime.Scheme
-	FullClient *hw.FullClient
+	Tink       *tink.Connection
+	Recorder   record.EventRecorder
+	// Signer signs the config urls embedded in the hardware metadata
+	Signer *configurl.Signer
+	// ConfigURLTTL is how long a config url is valid for, zero disables expiry
+	ConfigURLTTL time.Duration
+	// ConfigServerTLS generates https config urls
+	ConfigServerTLS bool
+	// ReconcileTimeout is how long a reconcile can run before the liveness check fails
+	ReconcileTimeout time.Duration
+	// NodeStabilizationPeriod is how long a node must be Ready before the Register is processed
+	NodeStabilizationPeriod time.Duration
+	// ProvisioningTimeout is how long a node has to become Ready once the hardware is pushed,
+	// unless overridden by the Register. Zero disables the timeout
+	ProvisioningTimeout time.Duration
+
+	tracker reconcileTracker
 }
 
 // +kubebuilder:rbac:groups=node.harvesterci.io,resources=registers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=node.harvesterci.io,resources=registers/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *RegisterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("register", req.NamespacedName)
+	defer r.tracker.track(req.Name)()
 
 	regoReq := &nodev1alpha1.Register{}
 
@@ -75,11 +108,19 @@ func (r *RegisterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		log.Error(err, "unable to fetch instance")
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
+	original := regoReq.DeepCopy()
 
 	if regoReq.ObjectMeta.DeletionTimestamp.IsZero() {
+		// set before any conditions are, so they are stamped with the current generation //
+		regoReq.Status.ObservedGeneration = regoReq.Generation
+		if reprovisionRequested(regoReq) {
+			return r.reprovision(ctx, original, regoReq)
+		}
+
 		// reconile object
 		var err error
 		newStatus := &nodev1alpha1.RegisterStatus{}
+		result := ctrl.Result{Requeue: true}
 
 		switch regoReq.Status.DeepCopy().Status {
 		case "":
@@ -88,53 +129,140 @@ func (r *RegisterReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		case UIDGenerated:
 			// make hardware call
 			newStatus, err = r.generateHardware(ctx, regoReq)
-		case HWPushed:
+		case HWPushed, Failed:
 			_, ok := regoReq.Labels["nodeReady"]
 			if ok {
-				return ctrl.Result{}, nil
+				return r.refreshNodeStatus(ctx, original, regoReq)
 			} else {
+				// re-push the hardware if the spec has changed while the node is yet to join //
+				var changed bool
+				newStatus, changed, err = r.syncHardware(ctx, regoReq)
+				if changed {
+					break
+				}
+
 				// check if node exists already in which case its time to label
-				ok, err := r.doesNodeExist(ctx, regoReq)
+				node, err := r.getNode(ctx, regoReq)
 				if err != nil {
 					return ctrl.Result{}, err
 				}
 
-				if !ok {
-					// node doest exist yet. Ignore and wait for watcher to requeue
-					return ctrl.Result{}, nil
-				} else {
-					regoReq.Labels["nodeReady"] = "true"
-					newStatus = regoReq.Status.DeepCopy()
-					newStatus.Status = NodeProcessed
+				// the node from before a reprovision is ignored until the reinstalled node joins //
+				if node != nil && !nodeJoined(node, newStatus) {
+					node = nil
+				}
+
+				var nodeStatus *nodev1alpha1.NodeStatus
+				stable, remaining := false, time.Duration(0)
+				if node != nil {
+					nodeStatus = observeNode(node)
+					newStatus.NodeName = node.Name
+					setNodeStatus(newStatus, node.Name, nodeStatus)
+					newStatus.SetCondition(nodev1alpha1.ConditionNodeJoined, metav1.ConditionTrue, "NodeFound", "node "+node.Name+" has joined the cluster")
+					stable, remaining = nodeStable(nodeStatus, r.NodeStabilizationPeriod, newStatus.LastReprovisionTime)
+
+					// the node is installed once it joins, so it is not reinstalled if it reboots
+					// before it is stable, or never becomes ready //
+					if len(regoReq.Status.NodeName) == 0 {
+						if err := r.disablePxe(ctx, regoReq, newStatus); err != nil {
+							r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "HardwarePushFailed", "%v", err)
+							return ctrl.Result{}, err
+						}
+					}
+				}
+
+				// wait for the node to join and be ready for the stabilization period. A failed register
+				// only waits for the node watch, in case the node joins after the timeout //
+				if !stable {
+					result = ctrl.Result{}
+					if newStatus.Status != Failed {
+						result = r.waitForNode(regoReq, newStatus, nodeStatus, remaining)
+					}
+					break
+				}
+
+				if err := r.uncordonNode(ctx, regoReq, node); err != nil {
+					return ctrl.Result{}, err
+				}
+
+				if err := r.revokeConfigURL(ctx, regoReq, newStatus); err != nil {
+					r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "HardwarePushFailed", "%v", err)
+					return ctrl.Result{}, err
 				}
+				regoReq.Labels["nodeReady"] = "true"
+				newStatus.SetPhase(NodeProcessed)
+				newStatus.FailureReason = ""
+				newStatus.Message = ""
+				newStatus.SetCondition(nodev1alpha1.ConditionReady, metav1.ConditionTrue, "NodeProcessed", "")
+				r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "NodeJoined", "node %s has joined the cluster", node.Name)
+				// reprovisioned and adopted registers are measured from the reprovision //
+				started := regoReq.CreationTimestamp.Time
+				if newStatus.LastReprovisionTime != nil {
+					started = newStatus.LastReprovisionTime.Time
+				}
+				metrics.ObserveNodeJoined(started)
 			}
 		case NodeProcessed:
-			return ctrl.Result{}, nil
+			return r.refreshNodeStatus(ctx, original, regoReq)
+		case Adopted:
+			// adopted hardware is left as is in tink until a reprovision is requested //
+			newStatus = regoReq.Status.DeepCopy()
+			newStatus.SetCondition(nodev1alpha1.ConditionReady, metav1.ConditionFalse, "Adopted", "increment spec.reprovisionGeneration to install the node")
+			result = ctrl.Result{}
 		}
 
+		if !newStatus.IsConditionTrue(nodev1alpha1.ConditionReady) && newStatus.Status != Failed && newStatus.Status != Adopted {
+			newStatus.SetCondition(nodev1alpha1.ConditionReady, metav1.ConditionFalse, "Provisioning", "waiting for phase "+newStatus.Status+" to complete")
+		}
 		regoReq.Status = *newStatus
 		controllerutil.AddFinalizer(regoReq, regoFinalizer)
 		if err != nil {
+			// persist the failure conditions, but return the reconcile error
+			if updateErr := r.updateRegister(ctx, original, regoReq); updateErr != nil {
+				log.Error(updateErr, "unable to update status")
+			}
 			return ctrl.Result{}, err
 		}
-		// always requeue since we want it to exit reconile loop via the switch flow //
-		return ctrl.Result{Requeue: true}, r.Update(ctx, regoReq)
+		// requeue unless waiting, since we want it to exit reconile loop via the switch flow. While
+		// waiting nothing is written unless the status changed, and the RequeueAfter drives the wait //
+		return result, r.updateRegister(ctx, original, regoReq)
 	} else {
 		if containsString(regoReq.ObjectMeta.Finalizers, regoFinalizer) {
 			if len(regoReq.Status.UUID) != 0 {
 				if err := r.deleteHardware(ctx, regoReq.Status.UUID); err != nil {
+					r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "HardwareDeleteFailed", "error deleting hardware %s: %v", regoReq.Status.UUID, err)
 					return ctrl.Result{}, err
 				}
+				r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "HardwareDeleted", "hardware %s deleted from tink", regoReq.Status.UUID)
 			}
 
+			controllerutil.RemoveFinalizer(regoReq, regoFinalizer)
+			if err := r.Update(ctx, regoReq); err != nil {
+				return ctrl.Result{}, err
+			}
+			r.Recorder.Event(regoReq, v1.EventTypeNormal, "FinalizerRemoved", "finalizer removed, register can be deleted")
 		}
-		controllerutil.RemoveFinalizer(regoReq, regoFinalizer)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// updateRegister persists the labels and finalizers of a register, followed by its status which is
+// a subresource, skipping the writes when nothing has changed since the original was fetched.
+// Update replaces the object with the stored one, so the status is restored in between //
+func (r *RegisterReconciler) updateRegister(ctx context.Context, original, regoReq *nodev1alpha1.Register) error {
+	regoStatus := regoReq.Status.DeepCopy()
+	if !reflect.DeepEqual(original.ObjectMeta, regoReq.ObjectMeta) {
 		if err := r.Update(ctx, regoReq); err != nil {
-			return ctrl.Result{}, err
+			return err
 		}
+		regoReq.Status = *regoStatus
 	}
 
-	return ctrl.Result{}, nil
+	if reflect.DeepEqual(&original.Status, regoStatus) {
+		return nil
+	}
+	return r.Status().Update(ctx, regoReq)
 }
 
 func (r *RegisterReconciler) SetupWithManager(mgr ctrl.Manager) error {
@@ -143,20 +271,37 @@ func (r *RegisterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		For(&nodev1alpha1.Register{}).
 		Watches(&source.Kind{Type: &v1.Node{}},
 			&handler.EnqueueRequestsFromMapFunc{
-				ToRequests: handler.ToRequestsFunc(func(a handler.MapObject) []reconcile.Request {
-					return []reconcile.Request{
-						{
-							NamespacedName: types.NamespacedName{
-								Name:      a.Meta.GetName(),
-								Namespace: a.Meta.GetNamespace(),
-							},
-						},
-					}
-				}),
+				ToRequests: handler.ToRequestsFunc(r.nodeToRegisters),
 			}).
 		Complete(r)
 }
 
+// nodeToRegisters maps a node to the registers matched to it, and the registers still waiting
+// for their node which match it //
+func (r *RegisterReconciler) nodeToRegisters(a handler.MapObject) []reconcile.Request {
+	node, ok := a.Object.(*v1.Node)
+	if !ok {
+		return nil
+	}
+
+	registerList := &nodev1alpha1.RegisterList{}
+	if err := r.List(context.Background(), registerList); err != nil {
+		r.Log.Error(err, "unable to list registers", "node", node.Name)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for i := range registerList.Items {
+		register := &registerList.Items[i]
+		if register.Status.NodeName == node.Name || (len(register.Status.NodeName) == 0 && len(matchNode(register, node)) != 0) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Name: register.Name},
+			})
+		}
+	}
+	return requests
+}
+
 // containsString is a helper to check if finalizer exists
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
@@ -173,8 +318,20 @@ func (r *RegisterReconciler) generateUID(regoReq *nodev1alpha1.Register) (regoSt
 	labels := regoReq.GetLabels()
 	if labels != nil {
 		regoID, ok := labels["uuid"]
+		if ok && regoReq.Annotations[tink.AdoptedAnnotation] == "true" {
+			// the existing hardware is not pushed, so it is not changed until a reprovision //
+			regoStatus.UUID = regoID
+			regoStatus.SetPhase(Adopted)
+			regoStatus.SetCondition(nodev1alpha1.ConditionUUIDAllocated, metav1.ConditionTrue, "UUIDFromLabel", "")
+			regoStatus.SetCondition(nodev1alpha1.ConditionHardwarePublished, metav1.ConditionTrue, "Adopted", "existing hardware adopted from tink")
+			r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "UUIDAllocated", "using uuid %s of adopted hardware", regoID)
+			return regoStatus, nil
+		}
 		if ok {
 			regoStatus.UUID = regoID
+			regoStatus.SetPhase(UIDGenerated)
+			regoStatus.SetCondition(nodev1alpha1.ConditionUUIDAllocated, metav1.ConditionTrue, "UUIDFromLabel", "")
+			r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "UUIDAllocated", "using uuid %s from label", regoID)
 			return regoStatus, nil
 		}
 	} else {
@@ -185,7 +342,9 @@ func (r *RegisterReconciler) generateUID(regoReq *nodev1alpha1.Register) (regoSt
 	labels["uuid"] = uuid
 	regoReq.Labels = labels
 	regoStatus.UUID = uuid
-	regoStatus.Status = UIDGenerated
+	regoStatus.SetPhase(UIDGenerated)
+	regoStatus.SetCondition(nodev1alpha1.ConditionUUIDAllocated, metav1.ConditionTrue, "UUIDGenerated", "")
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "UUIDGenerated", "generated uuid %s", uuid)
 	return regoStatus, nil
 }
 
@@ -194,31 +353,160 @@ func (r *RegisterReconciler) generateHardware(ctx context.Context, regoReq *node
 
 	regoStatus = regoReq.Status.DeepCopy()
 
-	regoURL, err := util.FetchServerURL(r.Client)
+	defer func() {
+		if err != nil {
+			regoStatus.SetCondition(nodev1alpha1.ConditionHardwarePublished, metav1.ConditionFalse, "PushFailed", err.Error())
+			r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "HardwarePushFailed", "%v", err)
+		}
+	}()
+
+	// issue a new config url along with the hardware //
+	if err = configurl.Rotate(regoStatus, r.ConfigURLTTL); err != nil {
+		return regoStatus, err
+	}
+
+	hwRequest, hash, err := r.renderHardware(regoReq, regoStatus)
 	if err != nil {
-		return regoStatus, errors.Wrap(err, "error fetching server url")
+		return regoStatus, err
+	}
+
+	if err = r.pushHardware(ctx, hwRequest); err != nil {
+		return regoStatus, err
 	}
 
-	hwRequest, err := tink.GenerateHWRequest(regoReq, regoURL)
+	regoStatus.HardwareHash = hash
+	regoStatus.ReprovisionGeneration = regoReq.Spec.ReprovisionGeneration
+	regoStatus.SetPhase(HWPushed)
+	regoStatus.SetCondition(nodev1alpha1.ConditionHardwarePublished, metav1.ConditionTrue, "PushSucceeded", "")
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "HardwarePushed", "hardware %s pushed to tink", regoReq.Status.UUID)
+	return regoStatus, nil
+}
+
+// syncHardware re-pushes the hardware to tink if the rendered hardware has changed since it was last pushed //
+func (r *RegisterReconciler) syncHardware(ctx context.Context, regoReq *nodev1alpha1.Register) (regoStatus *nodev1alpha1.RegisterStatus, changed bool, err error) {
+	regoStatus = regoReq.Status.DeepCopy()
+
+	// renew the config url once expired, so the node can still be pxe booted //
+	if configurl.Expired(regoStatus) {
+		if err = configurl.Rotate(regoStatus, r.ConfigURLTTL); err != nil {
+			return regoStatus, true, err
+		}
+		r.Recorder.Event(regoReq, v1.EventTypeNormal, "ConfigURLRenewed", "expired config url renewed")
+	}
+
+	hwRequest, hash, err := r.renderHardware(regoReq, regoStatus)
 	if err != nil {
-		return regoStatus, errors.Wrap(err, "error during generatehwrequest")
+		return regoStatus, true, err
+	}
+
+	// generation is not used to detect changes, as the hardware also depends on the operator config //
+	if hash == regoReq.Status.HardwareHash {
+		return regoStatus, false, nil
+	}
+
+	regoStatus.HardwareHash = hash
+	current, err := r.getHardware(ctx, regoReq.Status.UUID)
+	if err == nil && tink.HardwareEqual(hwRequest, current) {
+		return regoStatus, true, nil
+	}
+
+	if err = r.pushHardware(ctx, hwRequest); err != nil {
+		regoStatus.SetCondition(nodev1alpha1.ConditionHardwarePublished, metav1.ConditionFalse, "PushFailed", err.Error())
+		r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "HardwarePushFailed", "%v", err)
+		return regoStatus, true, err
+	}
+
+	regoStatus.SetCondition(nodev1alpha1.ConditionHardwarePublished, metav1.ConditionTrue, "SpecChanged", "hardware re-pushed after spec change")
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "HardwareUpdated", "hardware %s re-pushed to tink after spec change", regoReq.Status.UUID)
+	return regoStatus, true, nil
+}
+
+// renderHardware generates the tink hardware for a register, along with a hash used to detect changes.
+// The config url is signed using the nonce and expiry from the passed status, and pxe is only allowed
+// until a node has joined for the register, or the status reaches the nodeprocessed phase //
+func (r *RegisterReconciler) renderHardware(regoReq *nodev1alpha1.Register, regoStatus *nodev1alpha1.RegisterStatus) (hwRequest *hardware.Hardware, hash string, err error) {
+	regoURL, err := util.ConfigServerURL(r.Client, r.ConfigServerTLS)
+	if err != nil {
+		return nil, hash, errors.Wrap(err, "error fetching server url")
+	}
+
+	// the node is installed once it joins, until a reprovision clears the node name and moves the
+	// register back to uidgenerated //
+	allowPxe := regoStatus.Status != NodeProcessed && len(regoStatus.NodeName) == 0
+	hwRequest, err = tink.GenerateHWRequest(regoReq, regoURL, r.Signer.Token(regoStatus), allowPxe)
+	if err != nil {
+		return nil, hash, errors.Wrap(err, "error during generatehwrequest")
 	}
 	bf := bytes.NewBuffer([]byte{})
 	customEncoder := json.NewEncoder(bf)
 	customEncoder.SetEscapeHTML(false)
 	err = customEncoder.Encode(hwRequest)
 	if err != nil {
-		return regoStatus, errors.Wrap(err, "error during hw request marshal")
+		return nil, hash, errors.Wrap(err, "error during hw request marshal")
+	}
+
+	// the metadata contains the signed config url, which grants access to the join token and password,
+	// so only the hash of the hardware is logged //
+	hash = fmt.Sprintf("%x", sha256.Sum256(bf.Bytes()))
+	r.Log.V(1).Info("rendered hardware", "uuid", hwRequest.Id, "hash", hash)
+	return hwRequest, hash, nil
+}
+
+// revokeConfigURL rotates the config url nonce once the node has joined, so urls issued during
+// the install can no longer be used, and pushes the hardware with the new url and pxe disabled
+// to tink. The status is only updated once the push succeeds //
+func (r *RegisterReconciler) revokeConfigURL(ctx context.Context, regoReq *nodev1alpha1.Register, regoStatus *nodev1alpha1.RegisterStatus) (err error) {
+	revoked := regoStatus.DeepCopy()
+	if err = configurl.Rotate(revoked, 0); err != nil {
+		return err
 	}
+	revoked.SetPhase(NodeProcessed)
 
-	r.Log.Info(string(bf.String()))
-	_, err = r.FullClient.HardwareClient.Push(ctx, &hardware.PushRequest{Data: hwRequest})
+	hwRequest, hash, err := r.renderHardware(regoReq, revoked)
 	if err != nil {
-		return regoStatus, errors.Wrap(err, "error during hardware push")
+		return err
 	}
 
-	regoStatus.Status = HWPushed
-	return regoStatus, nil
+	if err = r.pushHardware(ctx, hwRequest); err != nil {
+		return err
+	}
+
+	regoStatus.ConfigURLNonce = revoked.ConfigURLNonce
+	regoStatus.ConfigURLExpiry = revoked.ConfigURLExpiry
+	regoStatus.HardwareHash = hash
+	return nil
+}
+
+// disablePxe pushes the hardware with pxe disabled once a node has joined for the register. The config
+// url stays valid until the node is stable, as it is revoked by revokeConfigURL //
+func (r *RegisterReconciler) disablePxe(ctx context.Context, regoReq *nodev1alpha1.Register, regoStatus *nodev1alpha1.RegisterStatus) error {
+	hwRequest, hash, err := r.renderHardware(regoReq, regoStatus)
+	if err != nil {
+		return err
+	}
+
+	if err = r.pushHardware(ctx, hwRequest); err != nil {
+		return err
+	}
+
+	regoStatus.HardwareHash = hash
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "PXEDisabled", "pxe disabled for hardware %s as node %s has joined", regoReq.Status.UUID, regoStatus.NodeName)
+	return nil
+}
+
+func (r *RegisterReconciler) pushHardware(ctx context.Context, hwRequest *hardware.Hardware) (err error) {
+	r.Log.Info("pushing hardware to tink", "uuid", hwRequest.Id)
+	start := time.Now()
+	hardwareClient, err := r.Tink.HardwareClient()
+	if err != nil {
+		return err
+	}
+	_, err = hardwareClient.Push(ctx, &hardware.PushRequest{Data: hwRequest})
+	metrics.ObserveTinkRequest(metrics.OperationPush, start, err)
+	if err != nil {
+		return errors.Wrap(err, "error during hardware push")
+	}
+	return nil
 }
 
 func (r *RegisterReconciler) deleteHardware(ctx context.Context, uuid string) (err error) {
@@ -231,27 +519,123 @@ func (r *RegisterReconciler) deleteHardware(ctx context.Context, uuid string) (e
 		}
 	}
 
-	_, err = r.FullClient.HardwareClient.Delete(ctx, &hardware.DeleteRequest{Id: uuid})
+	hardwareClient, err := r.Tink.HardwareClient()
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	_, err = hardwareClient.Delete(ctx, &hardware.DeleteRequest{Id: uuid})
+	metrics.ObserveTinkRequest(metrics.OperationDelete, start, err)
 	return err
 }
 
 func (r *RegisterReconciler) getHardware(ctx context.Context, uuid string) (hw *hardware.Hardware, err error) {
-	hw, err = r.FullClient.HardwareClient.ByID(ctx, &hardware.GetRequest{Id: uuid})
+	hardwareClient, err := r.Tink.HardwareClient()
+	if err != nil {
+		return nil, err
+	}
+	hw, err = hardwareClient.ByID(ctx, &hardware.GetRequest{Id: uuid})
 	return hw, err
 }
 
-func (r *RegisterReconciler) doesNodeExist(ctx context.Context, regoReq *nodev1alpha1.Register) (ok bool, err error) {
-	node := &v1.Node{}
-	err = r.Get(ctx, types.NamespacedName{Namespace: regoReq.Namespace, Name: regoReq.Name}, node)
+// getNode returns the node matched to a register, or nil if the node has not joined yet. Once
+// matched the node is looked up by the name recorded in the status, else by the hostname, and
+// finally by matching the nodes not claimed by other registers //
+func (r *RegisterReconciler) getNode(ctx context.Context, regoReq *nodev1alpha1.Register) (node *v1.Node, err error) {
+	if len(regoReq.Status.NodeName) != 0 {
+		return r.getNodeByName(ctx, regoReq.Status.NodeName)
+	}
+
+	node, err = r.getNodeByName(ctx, regoReq.Hostname())
+	if err != nil || node != nil {
+		return node, err
+	}
+
+	nodeList := &v1.NodeList{}
+	if err = r.List(ctx, nodeList); err != nil {
+		return nil, errors.Wrap(err, "error listing nodes")
+	}
+
+	registerList := &nodev1alpha1.RegisterList{}
+	if err = r.List(ctx, registerList); err != nil {
+		return nil, errors.Wrap(err, "error listing registers")
+	}
+
+	claimed := make(map[string]bool)
+	for _, register := range registerList.Items {
+		if register.Name != regoReq.Name && len(register.Status.NodeName) != 0 {
+			claimed[register.Status.NodeName] = true
+		}
+	}
+
+	var matched []string
+	for i := range nodeList.Items {
+		if claimed[nodeList.Items[i].Name] {
+			continue
+		}
+		if reason := matchNode(regoReq, &nodeList.Items[i]); len(reason) != 0 {
+			r.Log.Info("matched node", "register", regoReq.Name, "node", nodeList.Items[i].Name, "reason", reason)
+			node = &nodeList.Items[i]
+			matched = append(matched, node.Name)
+		}
+	}
+
+	if len(matched) > 1 {
+		return nil, fmt.Errorf("register %s matches multiple nodes %v", regoReq.Name, matched)
+	}
+	return node, nil
+}
+
+// getNodeByName returns the named node, or nil if it does not exist
+func (r *RegisterReconciler) getNodeByName(ctx context.Context, name string) (node *v1.Node, err error) {
+	node = &v1.Node{}
+	err = r.Get(ctx, types.NamespacedName{Name: name}, node)
 	if err != nil {
 		if apierror.IsNotFound(err) {
-			return ok, nil
+			return nil, nil
 		} else {
-			return ok, err
+			return nil, err
 		}
 	}
 
-	// assuming node has been found and there were no errors finding it
-	ok = true
-	return ok, nil
+	return node, nil
+}
+
+// refreshNodeStatus keeps the node status of a processed register up to date, so kubelet
+// flaps and upgrades are visible on the register //
+func (r *RegisterReconciler) refreshNodeStatus(ctx context.Context, original, regoReq *nodev1alpha1.Register) (ctrl.Result, error) {
+	node, err := r.getNode(ctx, regoReq)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	regoStatus := regoReq.Status.DeepCopy()
+	regoStatus.ObservedGeneration = regoReq.Generation
+	nodeName := regoReq.Hostname()
+	if len(regoStatus.NodeName) != 0 {
+		nodeName = regoStatus.NodeName
+	}
+
+	var nodeStatus *nodev1alpha1.NodeStatus
+	if node != nil {
+		nodeName = node.Name
+		regoStatus.NodeName = node.Name
+		nodeStatus = observeNode(node)
+	}
+	setNodeStatus(regoStatus, nodeName, nodeStatus)
+	if reflect.DeepEqual(regoStatus, &original.Status) {
+		return ctrl.Result{}, nil
+	}
+
+	wasReady := original.Status.IsConditionTrue(nodev1alpha1.ConditionNodeReady)
+	isReady := regoStatus.IsConditionTrue(nodev1alpha1.ConditionNodeReady)
+	if wasReady && !isReady {
+		r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "NodeNotReady", "node %s is no longer ready", nodeName)
+	} else if !wasReady && isReady {
+		r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "NodeReady", "node %s is ready", nodeName)
+	}
+
+	regoReq.Status = *regoStatus
+	return ctrl.Result{}, r.updateRegister(ctx, original, regoReq)
 }
@@ -0,0 +1,200 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// drainRequeueInterval is how often the pods on a node being drained are checked
+const drainRequeueInterval = 10 * time.Second
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=update;delete
+// +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups="",resources=pods/eviction,verbs=create
+
+// reprovisionRequested checks if spec.reprovisionGeneration has changed since the hardware was pushed
+func reprovisionRequested(regoReq *nodev1alpha1.Register) bool {
+	switch regoReq.Status.Status {
+	case HWPushed, NodeProcessed, Failed, Adopted:
+		return regoReq.Spec.ReprovisionGeneration != regoReq.Status.ReprovisionGeneration
+	}
+	return false
+}
+
+// reprovision prepares a register for the node to be reinstalled. The existing node is handled
+// according to the reprovision node policy, and the register is moved back to the uidgenerated
+// phase so the hardware is pushed again with a new config url //
+func (r *RegisterReconciler) reprovision(ctx context.Context, original, regoReq *nodev1alpha1.Register) (ctrl.Result, error) {
+	node, err := r.getNode(ctx, regoReq)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if node != nil {
+		done, err := r.cleanupNode(ctx, regoReq, node)
+		if err != nil {
+			r.Recorder.Eventf(regoReq, v1.EventTypeWarning, "ReprovisionFailed", "%v", err)
+			return ctrl.Result{}, err
+		}
+		if !done {
+			return ctrl.Result{RequeueAfter: drainRequeueInterval}, nil
+		}
+	}
+
+	regoStatus := regoReq.Status.DeepCopy()
+	now := metav1.Now()
+	regoStatus.ReprovisionGeneration = regoReq.Spec.ReprovisionGeneration
+	regoStatus.LastReprovisionTime = &now
+	regoStatus.ReprovisionedBootID = ""
+	if node != nil {
+		regoStatus.ReprovisionedBootID = node.Status.NodeInfo.BootID
+	}
+	regoStatus.NodeName = ""
+	regoStatus.Node = nil
+	regoStatus.ConfigFetch = nil
+	regoStatus.FailureReason = ""
+	regoStatus.Message = ""
+	regoStatus.SetPhase(UIDGenerated)
+	regoStatus.SetCondition(nodev1alpha1.ConditionConfigServed, metav1.ConditionFalse, "Reprovisioning", "")
+	regoStatus.SetCondition(nodev1alpha1.ConditionNodeJoined, metav1.ConditionFalse, "Reprovisioning", "")
+	regoStatus.SetCondition(nodev1alpha1.ConditionNodeReady, metav1.ConditionFalse, "Reprovisioning", "")
+	regoStatus.SetCondition(nodev1alpha1.ConditionReady, metav1.ConditionFalse, "Reprovisioning", "node is being reinstalled")
+	regoReq.Status = *regoStatus
+
+	// re-enables the config server for the node //
+	delete(regoReq.Labels, "nodeReady")
+
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "Reprovisioning", "reinstalling node for reprovision generation %d", regoReq.Spec.ReprovisionGeneration)
+	return ctrl.Result{Requeue: true}, r.updateRegister(ctx, original, regoReq)
+}
+
+// cleanupNode applies the reprovision node policy to the existing node, and returns false
+// while pods are still being evicted from it
+func (r *RegisterReconciler) cleanupNode(ctx context.Context, regoReq *nodev1alpha1.Register, node *v1.Node) (done bool, err error) {
+	policy := regoReq.Spec.ReprovisionNodePolicy
+	if len(policy) == 0 || policy == nodev1alpha1.ReprovisionNodeKeep {
+		return true, nil
+	}
+
+	if !node.Spec.Unschedulable {
+		node.Spec.Unschedulable = true
+		if err := r.Update(ctx, node); err != nil {
+			return false, errors.Wrapf(err, "error cordoning node %s", node.Name)
+		}
+		r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "NodeCordoned", "node %s cordoned for reprovisioning", node.Name)
+	}
+	if policy == nodev1alpha1.ReprovisionNodeCordon {
+		return true, nil
+	}
+
+	drained, err := r.drainNode(ctx, node.Name)
+	if err != nil || !drained {
+		return false, err
+	}
+	if policy == nodev1alpha1.ReprovisionNodeDrain {
+		return true, nil
+	}
+
+	if err := r.Delete(ctx, node); err != nil && !apierror.IsNotFound(err) {
+		return false, errors.Wrapf(err, "error deleting node %s", node.Name)
+	}
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "NodeDeleted", "node %s deleted for reprovisioning", node.Name)
+	return true, nil
+}
+
+// drainNode evicts the pods on a node, and returns true once none are left. Evictions blocked
+// by a pod disruption budget are retried on the next reconcile
+func (r *RegisterReconciler) drainNode(ctx context.Context, nodeName string) (drained bool, err error) {
+	podList := &v1.PodList{}
+	if err := r.APIReader.List(ctx, podList, client.MatchingFields{"spec.nodeName": nodeName}); err != nil {
+		return false, errors.Wrapf(err, "error listing pods on node %s", nodeName)
+	}
+
+	drained = true
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if !evictable(pod) {
+			continue
+		}
+
+		drained = false
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		err := r.KubeClient.PolicyV1beta1().Evictions(pod.Namespace).Evict(&policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		})
+		if err != nil && !apierror.IsNotFound(err) && !apierror.IsTooManyRequests(err) {
+			return false, errors.Wrapf(err, "error evicting pod %s/%s", pod.Namespace, pod.Name)
+		}
+	}
+
+	return drained, nil
+}
+
+// evictable skips the pods which are not removed when draining a node, the same as kubectl drain
+func evictable(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+
+	if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+
+	return true
+}
+
+// uncordonNode makes a node schedulable again once it has been reinstalled, if it was cordoned
+// by the reprovision node policy
+func (r *RegisterReconciler) uncordonNode(ctx context.Context, regoReq *nodev1alpha1.Register, node *v1.Node) error {
+	if regoReq.Status.LastReprovisionTime == nil || !node.Spec.Unschedulable {
+		return nil
+	}
+
+	switch regoReq.Spec.ReprovisionNodePolicy {
+	case nodev1alpha1.ReprovisionNodeCordon, nodev1alpha1.ReprovisionNodeDrain:
+	default:
+		return nil
+	}
+
+	node.Spec.Unschedulable = false
+	if err := r.Update(ctx, node); err != nil {
+		return errors.Wrapf(err, "error uncordoning node %s", node.Name)
+	}
+	r.Recorder.Eventf(regoReq, v1.EventTypeNormal, "NodeUncordoned", "node %s uncordoned after reprovisioning", node.Name)
+	return nil
+}
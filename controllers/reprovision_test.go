@@ -0,0 +1,462 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testReconciler generates a reconciler backed by fake clients holding the objects passed. Evictions
+// are recorded in evicted, and fail with evictErr when set
+func testReconciler(t *testing.T, evictErr error, objs ...runtime.Object) (r *RegisterReconciler, evicted *[]string) {
+	if err := nodev1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	evicted = &[]string{}
+	kubeClient := kubefake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "pods", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		if evictErr != nil {
+			return true, nil, evictErr
+		}
+		*evicted = append(*evicted, action.(clienttesting.CreateAction).GetObject().(metav1.Object).GetName())
+		return true, nil, nil
+	})
+
+	c := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
+	return &RegisterReconciler{
+		Client:     c,
+		APIReader:  c,
+		KubeClient: kubeClient,
+		Log:        ctrl.Log.WithName("test"),
+		Scheme:     scheme.Scheme,
+		Recorder:   record.NewFakeRecorder(10),
+	}, evicted
+}
+
+func testPod(name string, mutate func(pod *v1.Pod)) *v1.Pod {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       v1.PodSpec{NodeName: "node1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	if mutate != nil {
+		mutate(pod)
+	}
+	return pod
+}
+
+func TestReprovisionRequested(t *testing.T) {
+	tests := []struct {
+		phase string
+		want  bool
+	}{
+		{phase: ""},
+		{phase: UIDGenerated},
+		{phase: HWPushed, want: true},
+		{phase: NodeProcessed, want: true},
+		{phase: Failed, want: true},
+		{phase: Adopted, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.phase, func(t *testing.T) {
+			regoReq := &nodev1alpha1.Register{
+				Spec:   nodev1alpha1.RegisterSpec{ReprovisionGeneration: 1},
+				Status: nodev1alpha1.RegisterStatus{Status: tt.phase},
+			}
+			if got := reprovisionRequested(regoReq); got != tt.want {
+				t.Errorf("reprovisionRequested() = %v, want %v", got, tt.want)
+			}
+
+			regoReq.Status.ReprovisionGeneration = 1
+			if reprovisionRequested(regoReq) {
+				t.Errorf("reprovisionRequested() = true once the generation is acted on, want false")
+			}
+		})
+	}
+}
+
+func TestEvictable(t *testing.T) {
+	tests := []struct {
+		name string
+		pod  *v1.Pod
+		want bool
+	}{
+		{
+			name: "running pod",
+			pod:  testPod("web", nil),
+			want: true,
+		},
+		{
+			name: "mirror pod",
+			pod: testPod("kube-apiserver", func(pod *v1.Pod) {
+				pod.Annotations = map[string]string{v1.MirrorPodAnnotationKey: "hash"}
+			}),
+		},
+		{
+			name: "succeeded pod",
+			pod: testPod("job", func(pod *v1.Pod) {
+				pod.Status.Phase = v1.PodSucceeded
+			}),
+		},
+		{
+			name: "failed pod",
+			pod: testPod("job", func(pod *v1.Pod) {
+				pod.Status.Phase = v1.PodFailed
+			}),
+		},
+		{
+			name: "daemonset pod",
+			pod: testPod("agent", func(pod *v1.Pod) {
+				controller := true
+				pod.OwnerReferences = []metav1.OwnerReference{{Kind: "DaemonSet", Name: "agent", Controller: &controller}}
+			}),
+		},
+		{
+			name: "replicaset pod",
+			pod: testPod("web", func(pod *v1.Pod) {
+				controller := true
+				pod.OwnerReferences = []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &controller}}
+			}),
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := evictable(tt.pod); got != tt.want {
+				t.Errorf("evictable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanupNode(t *testing.T) {
+	pdbBlocked := apierror.NewTooManyRequests("cannot evict pod as it would violate the pod's disruption budget", 0)
+	evictFailed := apierror.NewForbidden(schema.GroupResource{Resource: "pods"}, "web", nil)
+
+	tests := []struct {
+		name     string
+		policy   string
+		pods     []runtime.Object
+		evictErr error
+		// the expected result, whether the node is cordoned or deleted, and the pods evicted
+		wantDone          bool
+		wantErr           bool
+		wantUnschedulable bool
+		wantDeleted       bool
+		wantEvicted       int
+	}{
+		{
+			name:     "no policy",
+			wantDone: true,
+		},
+		{
+			name:     "keep",
+			policy:   nodev1alpha1.ReprovisionNodeKeep,
+			pods:     []runtime.Object{testPod("web", nil)},
+			wantDone: true,
+		},
+		{
+			name:              "cordon",
+			policy:            nodev1alpha1.ReprovisionNodeCordon,
+			pods:              []runtime.Object{testPod("web", nil)},
+			wantDone:          true,
+			wantUnschedulable: true,
+		},
+		{
+			name:              "drain evicts pods",
+			policy:            nodev1alpha1.ReprovisionNodeDrain,
+			pods:              []runtime.Object{testPod("web", nil), testPod("db", nil)},
+			wantUnschedulable: true,
+			wantEvicted:       2,
+		},
+		{
+			name:   "drain waits for terminating pods",
+			policy: nodev1alpha1.ReprovisionNodeDrain,
+			pods: []runtime.Object{testPod("web", func(pod *v1.Pod) {
+				now := metav1.Now()
+				pod.DeletionTimestamp = &now
+			})},
+			wantUnschedulable: true,
+		},
+		{
+			name:   "drain skips pods which are not evictable",
+			policy: nodev1alpha1.ReprovisionNodeDrain,
+			pods: []runtime.Object{testPod("job", func(pod *v1.Pod) {
+				pod.Status.Phase = v1.PodSucceeded
+			})},
+			wantDone:          true,
+			wantUnschedulable: true,
+		},
+		{
+			name:              "drain retries evictions blocked by a disruption budget",
+			policy:            nodev1alpha1.ReprovisionNodeDrain,
+			pods:              []runtime.Object{testPod("web", nil)},
+			evictErr:          pdbBlocked,
+			wantUnschedulable: true,
+		},
+		{
+			name:              "drain eviction error",
+			policy:            nodev1alpha1.ReprovisionNodeDrain,
+			pods:              []runtime.Object{testPod("web", nil)},
+			evictErr:          evictFailed,
+			wantErr:           true,
+			wantUnschedulable: true,
+		},
+		{
+			name:              "delete waits for the drain",
+			policy:            nodev1alpha1.ReprovisionNodeDelete,
+			pods:              []runtime.Object{testPod("web", nil)},
+			wantUnschedulable: true,
+			wantEvicted:       1,
+		},
+		{
+			name:        "delete once drained",
+			policy:      nodev1alpha1.ReprovisionNodeDelete,
+			wantDone:    true,
+			wantDeleted: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}}
+			r, evicted := testReconciler(t, tt.evictErr, append(tt.pods, node)...)
+			regoReq := &nodev1alpha1.Register{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       nodev1alpha1.RegisterSpec{ReprovisionNodePolicy: tt.policy},
+			}
+
+			done, err := r.cleanupNode(context.Background(), regoReq, node.DeepCopy())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("cleanupNode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if done != tt.wantDone {
+				t.Errorf("cleanupNode() done = %v, want %v", done, tt.wantDone)
+			}
+			if len(*evicted) != tt.wantEvicted {
+				t.Errorf("cleanupNode() evicted %v, want %d pods", *evicted, tt.wantEvicted)
+			}
+
+			current, err := r.getNodeByName(context.Background(), "node1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted := current == nil; deleted != tt.wantDeleted {
+				t.Fatalf("cleanupNode() deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if current != nil && current.Spec.Unschedulable != tt.wantUnschedulable {
+				t.Errorf("cleanupNode() unschedulable = %v, want %v", current.Spec.Unschedulable, tt.wantUnschedulable)
+			}
+		})
+	}
+}
+
+func TestUncordonNode(t *testing.T) {
+	reprovisioned := metav1.Now()
+
+	tests := []struct {
+		name          string
+		policy        string
+		reprovisioned *metav1.Time
+		unschedulable bool
+		want          bool
+	}{
+		{
+			name:          "never reprovisioned",
+			policy:        nodev1alpha1.ReprovisionNodeCordon,
+			unschedulable: true,
+			want:          true,
+		},
+		{
+			name:          "cordoned by the cordon policy",
+			policy:        nodev1alpha1.ReprovisionNodeCordon,
+			reprovisioned: &reprovisioned,
+			unschedulable: true,
+		},
+		{
+			name:          "cordoned by the drain policy",
+			policy:        nodev1alpha1.ReprovisionNodeDrain,
+			reprovisioned: &reprovisioned,
+			unschedulable: true,
+		},
+		{
+			name:          "cordoned with the keep policy",
+			policy:        nodev1alpha1.ReprovisionNodeKeep,
+			reprovisioned: &reprovisioned,
+			unschedulable: true,
+			want:          true,
+		},
+		{
+			name:          "recreated with the delete policy",
+			policy:        nodev1alpha1.ReprovisionNodeDelete,
+			reprovisioned: &reprovisioned,
+			unschedulable: true,
+			want:          true,
+		},
+		{
+			name:          "schedulable",
+			policy:        nodev1alpha1.ReprovisionNodeCordon,
+			reprovisioned: &reprovisioned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       v1.NodeSpec{Unschedulable: tt.unschedulable},
+			}
+			r, _ := testReconciler(t, nil, node)
+			regoReq := &nodev1alpha1.Register{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Spec:       nodev1alpha1.RegisterSpec{ReprovisionNodePolicy: tt.policy},
+				Status:     nodev1alpha1.RegisterStatus{LastReprovisionTime: tt.reprovisioned},
+			}
+
+			if err := r.uncordonNode(context.Background(), regoReq, node.DeepCopy()); err != nil {
+				t.Fatal(err)
+			}
+
+			current, err := r.getNodeByName(context.Background(), "node1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if current.Spec.Unschedulable != tt.want {
+				t.Errorf("uncordonNode() unschedulable = %v, want %v", current.Spec.Unschedulable, tt.want)
+			}
+		})
+	}
+}
+
+func TestReprovision(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		pods   []runtime.Object
+		// wantReset is true when the register is moved back to uidgenerated
+		wantReset  bool
+		wantResult ctrl.Result
+	}{
+		{
+			name:       "keep",
+			policy:     nodev1alpha1.ReprovisionNodeKeep,
+			pods:       []runtime.Object{testPod("web", nil)},
+			wantReset:  true,
+			wantResult: ctrl.Result{Requeue: true},
+		},
+		{
+			name:       "drain in progress",
+			policy:     nodev1alpha1.ReprovisionNodeDrain,
+			pods:       []runtime.Object{testPod("web", nil)},
+			wantResult: ctrl.Result{RequeueAfter: drainRequeueInterval},
+		},
+		{
+			name:       "drained",
+			policy:     nodev1alpha1.ReprovisionNodeDrain,
+			wantReset:  true,
+			wantResult: ctrl.Result{Requeue: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+				Status:     v1.NodeStatus{NodeInfo: v1.NodeSystemInfo{BootID: "boot-1"}},
+			}
+			regoReq := &nodev1alpha1.Register{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "node1",
+					Labels: map[string]string{"uuid": "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e", "nodeReady": "true"},
+				},
+				Spec: nodev1alpha1.RegisterSpec{
+					ReprovisionGeneration: 1,
+					ReprovisionNodePolicy: tt.policy,
+				},
+				Status: nodev1alpha1.RegisterStatus{
+					Status:   NodeProcessed,
+					UUID:     "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e",
+					NodeName: "node1",
+					Node:     &nodev1alpha1.NodeStatus{Ready: true},
+				},
+			}
+			r, _ := testReconciler(t, nil, append(tt.pods, node, regoReq.DeepCopy())...)
+
+			ctx := context.Background()
+			original := regoReq.DeepCopy()
+			result, err := r.reprovision(ctx, original, regoReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tt.wantResult {
+				t.Errorf("reprovision() result = %+v, want %+v", result, tt.wantResult)
+			}
+
+			current := &nodev1alpha1.Register{}
+			if err := r.Get(ctx, types.NamespacedName{Name: "node1"}, current); err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.wantReset {
+				if current.Status.Status != NodeProcessed || current.Status.ReprovisionGeneration != 0 {
+					t.Errorf("reprovision() phase = %s, generation = %d, want the register unchanged while draining", current.Status.Status, current.Status.ReprovisionGeneration)
+				}
+				return
+			}
+
+			if current.Status.Status != UIDGenerated {
+				t.Errorf("reprovision() phase = %s, want %s", current.Status.Status, UIDGenerated)
+			}
+			if current.Status.ReprovisionGeneration != 1 || current.Status.LastReprovisionTime == nil {
+				t.Errorf("reprovision() generation = %d, lastReprovisionTime = %v, want the reprovision recorded", current.Status.ReprovisionGeneration, current.Status.LastReprovisionTime)
+			}
+			if current.Status.ReprovisionedBootID != "boot-1" {
+				t.Errorf("reprovision() reprovisionedBootID = %q, want the boot id of the node", current.Status.ReprovisionedBootID)
+			}
+			if len(current.Status.NodeName) != 0 || current.Status.Node != nil {
+				t.Errorf("reprovision() nodeName = %q, want the node cleared", current.Status.NodeName)
+			}
+			if _, ok := current.Labels["nodeReady"]; ok {
+				t.Errorf("reprovision() kept the nodeReady label")
+			}
+			if current.Labels["uuid"] != regoReq.Status.UUID {
+				t.Errorf("reprovision() uuid label = %q, want %q", current.Labels["uuid"], regoReq.Status.UUID)
+			}
+		})
+	}
+}
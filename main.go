@@ -17,23 +17,28 @@ limitations under the License.
 package main
 
 import (
-	"context"
+	"crypto/tls"
 	"flag"
 	"os"
-
-	web "net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
 	"github.com/ibrokethecloud/harvester-tink-operator/controllers"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/configurl"
 	"github.com/ibrokethecloud/harvester-tink-operator/pkg/http"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/metrics"
 	"github.com/ibrokethecloud/harvester-tink-operator/pkg/tink"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/webhook"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -52,34 +57,128 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var enableWebhooks bool
+	var hardwareSyncInterval time.Duration
+	var gcOrphanedHardware bool
+	var adoptOrphanedHardware bool
+	var configURLTTL time.Duration
+	var singleUseConfigURLs bool
+	var bindConfigURLToIP bool
+	var configServerTLS string
+	var configServerCertDir string
+	var configServerHTTPAddr string
+	var configServerHTTPSAddr string
+	var operatorConfigMap string
+	var tinkRetryInterval time.Duration
+	var healthProbeAddr string
+	var reconcileTimeout time.Duration
+	var nodeStabilizationPeriod time.Duration
+	var provisioningTimeout time.Duration
+	var configServerShutdownTimeout time.Duration
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Enable admission webhooks for Register objects. "+
+			"Serving certs are expected in /tmp/k8s-webhook-server/serving-certs.")
+	flag.DurationVar(&hardwareSyncInterval, "hardware-sync-interval", 10*time.Minute,
+		"Interval at which hardware in tink is reconciled against Register objects.")
+	flag.BoolVar(&gcOrphanedHardware, "gc-orphaned-hardware", false,
+		"Delete hardware in tink which has no matching Register object.")
+	flag.BoolVar(&adoptOrphanedHardware, "adopt-orphaned-hardware", false,
+		"Create Register objects for hardware in tink which has no matching Register object. "+
+			"Takes precedence over gc-orphaned-hardware.")
+	flag.DurationVar(&configURLTTL, "config-url-ttl", 0,
+		"How long a signed config url is valid for. Expired urls are renewed until the node joins. "+
+			"Zero disables expiry.")
+	flag.BoolVar(&singleUseConfigURLs, "single-use-config-urls", false,
+		"Rotate the signed config url of a Register once its config has been served.")
+	flag.BoolVar(&bindConfigURLToIP, "bind-config-url-to-ip", false,
+		"Only serve the config to the ip addresses in the tink dhcp records of the hardware.")
+	flag.StringVar(&configServerTLS, "config-server-tls", "http",
+		"Protocols served by the config server, one of http, https or both. "+
+			"Config urls use https when https is served.")
+	flag.StringVar(&configServerCertDir, "config-server-cert-dir", "/tmp/config-server/serving-certs",
+		"Directory containing the tls.crt and tls.key used by the https config server.")
+	flag.StringVar(&configServerHTTPAddr, "config-server-http-addr", ":"+nodev1alpha1.DefaultConfigURLPort,
+		"The address the http config server binds to.")
+	flag.StringVar(&configServerHTTPSAddr, "config-server-https-addr", ":"+nodev1alpha1.DefaultConfigTLSPort,
+		"The address the https config server binds to.")
+	flag.StringVar(&operatorConfigMap, "operator-config-map", config.DefaultConfigMapName,
+		"Configmap in the operator namespace overriding the operator settings. "+
+			"Changes are applied without restarting the operator.")
+	flag.StringVar(&healthProbeAddr, "health-probe-addr", ":8081",
+		"The address the /healthz and /readyz probe endpoints bind to.")
+	flag.DurationVar(&configServerShutdownTimeout, "config-server-shutdown-timeout", 5*time.Second,
+		"How long in-flight config requests are given to complete on shutdown.")
+	flag.DurationVar(&nodeStabilizationPeriod, "node-stabilization-period", time.Minute,
+		"How long a node must be Ready before its Register is marked as processed.")
+	flag.DurationVar(&provisioningTimeout, "provisioning-timeout", 0,
+		"How long a node has to become Ready once its hardware is pushed to tink, before the Register is marked as failed. "+
+			"Can be overridden by spec.provisioningTimeout on a Register. 0 disables the timeout.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 5*time.Minute,
+		"How long a Register reconcile can run before the liveness probe fails.")
+	flag.DurationVar(&tinkRetryInterval, "tink-retry-interval", 30*time.Second,
+		"Interval at which the connection to tink is checked and re-established.")
+	operatorConfig := config.Default()
+	operatorConfig.AddFlags(flag.CommandLine)
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	config := ctrl.GetConfigOrDie()
+	var serveHTTP, serveHTTPS bool
+	switch configServerTLS {
+	case "http":
+		serveHTTP = true
+	case "https":
+		serveHTTPS = true
+	case "both":
+		serveHTTP, serveHTTPS = true, true
+	default:
+		setupLog.Info("invalid config-server-tls, must be one of http, https or both", "config-server-tls", configServerTLS)
+		os.Exit(1)
+	}
+
+	restConfig := ctrl.GetConfigOrDie()
 
-	// Need to check CM exists //
-	nonMgrClient, err := client.New(config, client.Options{})
+	// used to read settings before the manager cache is started //
+	nonMgrClient, err := client.New(restConfig, client.Options{})
 
 	if err != nil {
 		setupLog.Error(err, "unable to create non manager client")
 	}
 
-	fullClient, err := tink.NewClient(nonMgrClient)
+	if err := operatorConfig.Validate(); err != nil {
+		setupLog.Error(err, "invalid operator config flags")
+		os.Exit(1)
+	}
+
+	namespace := os.Getenv("namespace")
+	if len(namespace) == 0 {
+		namespace = nodev1alpha1.ConfigMapNamespace
+	}
+
+	cfg, err := config.Load(nonMgrClient, operatorConfig, operatorConfigMap, namespace)
+	if err != nil {
+		setupLog.Error(err, "unable to load operator config, using flags")
+		cfg = operatorConfig
+	}
+	config.Set(cfg)
+
+	signer, err := configurl.LoadSigner(nonMgrClient)
 	if err != nil {
-		setupLog.Error(err, "unable to create tink client")
+		setupLog.Error(err, "unable to load config url signing key")
 		os.Exit(1)
 	}
-	mgr, err := ctrl.NewManager(config, ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "9380a13a.harvesterci.io",
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		Port:                   9443,
+		HealthProbeBindAddress: healthProbeAddr,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "9380a13a.harvesterci.io",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -87,39 +186,143 @@ func main() {
 	}
 
 	client := mgr.GetClient()
+	metrics.Register(client)
+
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
 
-	if err = (&controllers.RegisterReconciler{
-		Client:     client,
-		Log:        ctrl.Log.WithName("controllers").WithName("Register"),
-		Scheme:     mgr.GetScheme(),
-		FullClient: fullClient,
-	}).SetupWithManager(mgr); err != nil {
+	tinkConnection := &tink.Connection{
+		APIReader:     mgr.GetAPIReader(),
+		KubeClient:    kubeClient,
+		Log:           ctrl.Log.WithName("tink"),
+		RetryInterval: tinkRetryInterval,
+	}
+	if err = mgr.Add(tinkConnection); err != nil {
+		setupLog.Error(err, "unable to add tink connection")
+		os.Exit(1)
+	}
+
+	registerReconciler := &controllers.RegisterReconciler{
+		Client:                  client,
+		APIReader:               mgr.GetAPIReader(),
+		KubeClient:              kubeClient,
+		Log:                     ctrl.Log.WithName("controllers").WithName("Register"),
+		Scheme:                  mgr.GetScheme(),
+		Tink:                    tinkConnection,
+		ReconcileTimeout:        reconcileTimeout,
+		NodeStabilizationPeriod: nodeStabilizationPeriod,
+		ProvisioningTimeout:     provisioningTimeout,
+		Recorder:                mgr.GetEventRecorderFor("register-controller"),
+		Signer:                  signer,
+		ConfigURLTTL:            configURLTTL,
+		ConfigServerTLS:         serveHTTPS,
+	}
+	if err = registerReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Register")
 		os.Exit(1)
 	}
 
+	if err = mgr.Add(&controllers.HardwareSync{
+		RegisterReconciler: registerReconciler,
+		Interval:           hardwareSyncInterval,
+		GCOrphans:          gcOrphanedHardware,
+		AdoptOrphans:       adoptOrphanedHardware,
+	}); err != nil {
+		setupLog.Error(err, "unable to add hardware sync")
+		os.Exit(1)
+	}
+
+	if err = mgr.Add(&controllers.OperatorConfigWatcher{
+		APIReader:  mgr.GetAPIReader(),
+		KubeClient: kubeClient,
+		Log:        ctrl.Log.WithName("config"),
+		Recorder:   mgr.GetEventRecorderFor("harvester-tink-operator"),
+		Base:       operatorConfig,
+		Name:       operatorConfigMap,
+		Namespace:  namespace,
+	}); err != nil {
+		setupLog.Error(err, "unable to add operator config watcher")
+		os.Exit(1)
+	}
+
+	if enableWebhooks {
+		mgr.GetWebhookServer().Register(webhook.DefaultPath, &admission.Webhook{
+			Handler: &webhook.RegisterDefaulter{
+				Client: client,
+				Log:    ctrl.Log.WithName("webhooks").WithName("Register"),
+			},
+		})
+		mgr.GetWebhookServer().Register(webhook.ValidatePath, &admission.Webhook{
+			Handler: &webhook.RegisterValidator{
+				Client: client,
+				Log:    ctrl.Log.WithName("webhooks").WithName("Register"),
+			},
+		})
+	}
+
 	// api server to serve config objects
 	router := mux.NewRouter()
 	configServer := http.ConfigServer{
-		Client: client,
-		Log:    ctrl.Log.WithName("webserver").WithName("config"),
+		Client:       client,
+		APIReader:    mgr.GetAPIReader(),
+		Log:          ctrl.Log.WithName("webserver").WithName("config"),
+		Recorder:     mgr.GetEventRecorderFor("harvester-tink-operator-config"),
+		Signer:       signer,
+		SingleUse:    singleUseConfigURLs,
+		ConfigURLTTL: configURLTTL,
+		BindClientIP: bindConfigURLToIP,
+		Tink:         tinkConnection,
 	}
 	configServer.SetupRoutes(router)
 
-	webServer := web.Server{
-		Addr:    ":" + nodev1alpha1.DefaultConfigURLPort,
-		Handler: router,
+	var configServers []*http.Server
+	if serveHTTP {
+		configServers = append(configServers, &http.Server{
+			Addr:            configServerHTTPAddr,
+			Handler:         router,
+			ShutdownTimeout: configServerShutdownTimeout,
+			Log:             ctrl.Log.WithName("webserver").WithName("http"),
+		})
+	}
+
+	if serveHTTPS {
+		certReloader := &http.CertReloader{CertDir: configServerCertDir}
+		if err := certReloader.Validate(); err != nil {
+			setupLog.Error(err, "unable to load config server certificate")
+			os.Exit(1)
+		}
+
+		configServers = append(configServers, &http.Server{
+			Addr:            configServerHTTPSAddr,
+			Handler:         router,
+			TLSConfig:       &tls.Config{GetCertificate: certReloader.GetCertificate},
+			ShutdownTimeout: configServerShutdownTimeout,
+			Log:             ctrl.Log.WithName("webserver").WithName("https"),
+		})
 	}
-	go func() {
-		err = webServer.ListenAndServe()
-		if err != nil {
+
+	for _, server := range configServers {
+		if err = mgr.Add(server); err != nil {
+			setupLog.Error(err, "unable to add config server")
 			os.Exit(1)
 		}
-	}()
+		if err = mgr.AddReadyzCheck("config-server-"+server.Addr, server.ReadyCheck); err != nil {
+			setupLog.Error(err, "unable to add config server readiness check")
+			os.Exit(1)
+		}
+	}
 
-	defer func() {
-		_ = webServer.Shutdown(context.Background())
-	}()
+	if err = mgr.AddReadyzCheck("tink", tinkConnection.ReadyCheck); err != nil {
+		setupLog.Error(err, "unable to add tink readiness check")
+		os.Exit(1)
+	}
+	if err = mgr.AddHealthzCheck("reconciler", registerReconciler.LivenessCheck); err != nil {
+		setupLog.Error(err, "unable to add reconciler liveness check")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -128,4 +331,8 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the manager does not wait for runnables to stop, so wait for in-flight config requests to drain //
+	for _, server := range configServers {
+		server.Wait()
+	}
 }
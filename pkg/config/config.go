@@ -0,0 +1,240 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultConfigMapName is the configmap in the operator namespace which overrides the flags
+	DefaultConfigMapName = "harvester-tink-operator-config"
+	versionPlaceholder   = "{version}"
+)
+
+// Config contains the operator settings which can be changed at runtime. The flags
+// provide the base config, which is overridden by the keys in the operator configmap
+type Config struct {
+	// TinkConfigMapName and TinkConfigMapNamespace locate the configmap with the tink endpoints
+	TinkConfigMapName      string
+	TinkConfigMapNamespace string
+	// ServiceName is the service exposing the config server in the operator namespace
+	ServiceName string
+	// ConfigServerAddress replaces the node ip in the config urls when specified
+	ConfigServerAddress string
+	// ConfigURLPort and ConfigTLSPort are the ports used in the http and https config urls
+	ConfigURLPort string
+	ConfigTLSPort string
+	// JoinServerURL is the url nodes join the cluster with. When not specified it
+	// is generated from the node ip and JoinPort
+	JoinServerURL string
+	JoinPort      string
+	// ISOURLTemplate generates the iso url from the harvester version, with {version}
+	// replaced by the version. DefaultISOURL, if set, is used when the version can not be
+	// found, else the config request fails
+	ISOURLTemplate string
+	DefaultISOURL  string
+	DefaultSlug    string
+	DefaultDisk    string
+	// JoinTokenSecret locates the join token used when a Register does not specify one
+	JoinTokenSecret nodev1alpha1.SecretKeyReference
+	// SecretRefNamespaces is a comma separated list of the namespaces, besides the operator
+	// namespace, which Registers can reference secrets in. The secrets are read with the
+	// permissions of the operator, so Registers can not reference secrets anywhere
+	SecretRefNamespaces string
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(Default())
+}
+
+// Default returns the config matching the previously hard-coded settings
+func Default() *Config {
+	return &Config{
+		TinkConfigMapName:      nodev1alpha1.ConfigMapName,
+		TinkConfigMapNamespace: nodev1alpha1.ConfigMapNamespace,
+		ServiceName:            "harvester-tink-operator",
+		ConfigURLPort:          nodev1alpha1.DefaultConfigURLPort,
+		ConfigTLSPort:          nodev1alpha1.DefaultConfigTLSPort,
+		JoinPort:               "8443",
+		ISOURLTemplate:         "https://releases.rancher.com/harvester/{version}/harvester-{version}-amd64.iso",
+		DefaultSlug:            nodev1alpha1.DefaultSlug,
+		DefaultDisk:            nodev1alpha1.DefaultDisk,
+		JoinTokenSecret: nodev1alpha1.SecretKeyReference{
+			Name:      nodev1alpha1.JoinTokenSecretName,
+			Namespace: nodev1alpha1.JoinTokenSecretNamespace,
+			Key:       nodev1alpha1.JoinTokenSecretKey,
+		},
+	}
+}
+
+// Get returns the current config, which must not be modified
+func Get() *Config {
+	return current.Load().(*Config)
+}
+
+// Set replaces the current config
+func Set(c *Config) {
+	current.Store(c)
+}
+
+// fields maps the configmap keys to the config fields, and is also used to register the flags
+func (c *Config) fields() []field {
+	return []field{
+		{"tinkConfigMapName", "tink-config-map-name", &c.TinkConfigMapName, "Name of the configmap containing the tink endpoints."},
+		{"tinkConfigMapNamespace", "tink-config-map-namespace", &c.TinkConfigMapNamespace, "Namespace of the configmap containing the tink endpoints."},
+		{"serviceName", "service-name", &c.ServiceName, "Service exposing the config server in the operator namespace."},
+		{"configServerAddress", "config-server-address", &c.ConfigServerAddress, "Address used in the config urls instead of the node ip."},
+		{"configURLPort", "config-url-port", &c.ConfigURLPort, "Port used in http config urls."},
+		{"configTLSPort", "config-tls-port", &c.ConfigTLSPort, "Port used in https config urls."},
+		{"joinServerURL", "join-server-url", &c.JoinServerURL, "URL nodes join the cluster with. Generated from the node ip and join-port if empty."},
+		{"joinPort", "join-port", &c.JoinPort, "Port nodes join the cluster on when join-server-url is empty."},
+		{"isoURLTemplate", "iso-url-template", &c.ISOURLTemplate, "Template for the iso url, with {version} replaced by the harvester version."},
+		{"defaultISOURL", "default-iso-url", &c.DefaultISOURL, "ISO url used when the harvester version can not be found. Config requests fail if empty."},
+		{"defaultSlug", "default-slug", &c.DefaultSlug, "Slug used when a Register does not specify one."},
+		{"defaultDisk", "default-disk", &c.DefaultDisk, "Install disk used when a Register does not specify one."},
+		{"joinTokenSecretName", "join-token-secret-name", &c.JoinTokenSecret.Name, "Secret containing the cluster join token."},
+		{"joinTokenSecretNamespace", "join-token-secret-namespace", &c.JoinTokenSecret.Namespace, "Namespace of the secret containing the cluster join token."},
+		{"joinTokenSecretKey", "join-token-secret-key", &c.JoinTokenSecret.Key, "Key of the cluster join token in the secret."},
+		{"secretRefNamespaces", "secret-ref-namespaces", &c.SecretRefNamespaces, "Comma separated namespaces Registers can reference secrets in, besides the operator namespace."},
+	}
+}
+
+type field struct {
+	key   string
+	flag  string
+	value *string
+	usage string
+}
+
+// AddFlags registers a flag for each setting, defaulting to the current values
+func (c *Config) AddFlags(fs *flag.FlagSet) {
+	for _, f := range c.fields() {
+		fs.StringVar(f.value, f.flag, *f.value, f.usage)
+	}
+}
+
+// Merge returns a copy of the config with the values from the configmap data applied
+func (c *Config) Merge(data map[string]string) (merged *Config, err error) {
+	copied := *c
+	merged = &copied
+
+	known := make(map[string]bool)
+	for _, f := range merged.fields() {
+		known[f.key] = true
+		if value, ok := data[f.key]; ok {
+			*f.value = strings.TrimSpace(value)
+		}
+	}
+
+	for key := range data {
+		if !known[key] {
+			return nil, fmt.Errorf("unknown key %s", key)
+		}
+	}
+
+	return merged, merged.Validate()
+}
+
+// Validate checks the settings are usable
+func (c *Config) Validate() error {
+	for _, f := range c.fields() {
+		switch f.key {
+		case "configServerAddress", "joinServerURL", "defaultISOURL", "secretRefNamespaces":
+			// optional settings
+			continue
+		}
+		if len(*f.value) == 0 {
+			return fmt.Errorf("%s can not be empty", f.key)
+		}
+	}
+
+	for key, port := range map[string]string{"configURLPort": c.ConfigURLPort, "configTLSPort": c.ConfigTLSPort, "joinPort": c.JoinPort} {
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return fmt.Errorf("%s %s is not a valid port", key, port)
+		}
+	}
+
+	if len(c.JoinServerURL) != 0 {
+		if u, err := url.Parse(c.JoinServerURL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+			return fmt.Errorf("joinServerURL %s is not a valid url", c.JoinServerURL)
+		}
+	}
+
+	if !strings.Contains(c.ISOURLTemplate, versionPlaceholder) {
+		return fmt.Errorf("isoURLTemplate must contain %s", versionPlaceholder)
+	}
+
+	supported := false
+	for _, slug := range nodev1alpha1.SupportedSlugs {
+		if c.DefaultSlug == slug {
+			supported = true
+		}
+	}
+	if !supported {
+		return fmt.Errorf("defaultSlug %s is not one of %v", c.DefaultSlug, nodev1alpha1.SupportedSlugs)
+	}
+
+	if !filepath.IsAbs(c.DefaultDisk) {
+		return fmt.Errorf("defaultDisk %s is not an absolute path", c.DefaultDisk)
+	}
+
+	return nil
+}
+
+// SecretRefAllowed checks if Registers can reference secrets in a namespace
+func (c *Config) SecretRefAllowed(namespace string) bool {
+	operatorNamespace := os.Getenv("namespace")
+	if len(operatorNamespace) == 0 {
+		operatorNamespace = nodev1alpha1.ConfigMapNamespace
+	}
+	if namespace == operatorNamespace {
+		return true
+	}
+
+	for _, allowed := range strings.Split(c.SecretRefNamespaces, ",") {
+		if allowed = strings.TrimSpace(allowed); len(allowed) != 0 && allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// ISOURL generates the iso url for a harvester version
+func (c *Config) ISOURL(version string) string {
+	return strings.ReplaceAll(c.ISOURLTemplate, versionPlaceholder, version)
+}
+
+// Load reads the operator configmap and merges it in to the base config. The base
+// config is returned if the configmap does not exist
+func Load(reader client.Reader, base *Config, name, namespace string) (c *Config, err error) {
+	cm := &corev1.ConfigMap{}
+	err = reader.Get(context.Background(), types.NamespacedName{Name: name, Namespace: namespace}, cm)
+	if err != nil {
+		if apierror.IsNotFound(err) {
+			return base, nil
+		}
+		return nil, errors.Wrap(err, "error fetching operator configmap")
+	}
+
+	c, err = base.Merge(cm.Data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid operator configmap %s/%s", namespace, name)
+	}
+	return c, nil
+}
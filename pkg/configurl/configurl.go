@@ -0,0 +1,147 @@
+package configurl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierror "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	KeySecretName = "harvester-tink-operator-config-url-key"
+	keySecretKey  = "key"
+	keyLength     = 32
+)
+
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;create
+
+var (
+	ErrInvalidToken = errors.New("invalid config url token")
+	ErrExpiredToken = errors.New("config url token has expired")
+)
+
+// Signer generates and verifies the tokens embedded in the config urls. A token is
+// an hmac of the register uuid, the nonce and the expiry from the register status,
+// so rotating the nonce invalidates any previously issued urls
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// LoadSigner reads the signing key from the key secret in the operator namespace,
+// generating the secret if it does not exist yet
+func LoadSigner(c client.Client) (signer *Signer, err error) {
+	namespace := os.Getenv("namespace")
+	if len(namespace) == 0 {
+		namespace = nodev1alpha1.ConfigMapNamespace
+	}
+
+	secret := &corev1.Secret{}
+	err = c.Get(context.Background(), types.NamespacedName{Name: KeySecretName, Namespace: namespace}, secret)
+	if err == nil {
+		key, ok := secret.Data[keySecretKey]
+		if !ok || len(key) < keyLength {
+			return nil, fmt.Errorf("secret %s/%s does not contain a valid signing key", namespace, KeySecretName)
+		}
+		return NewSigner(key), nil
+	}
+
+	if !apierror.IsNotFound(err) {
+		return nil, errors.Wrap(err, "error fetching signing key secret")
+	}
+
+	key := make([]byte, keyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, errors.Wrap(err, "error generating signing key")
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      KeySecretName,
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{
+			keySecretKey: key,
+		},
+	}
+	if err := c.Create(context.Background(), secret); err != nil {
+		if apierror.IsAlreadyExists(err) {
+			// another replica created the key first
+			return LoadSigner(c)
+		}
+		return nil, errors.Wrap(err, "error creating signing key secret")
+	}
+
+	return NewSigner(key), nil
+}
+
+// Token generates the token for the current nonce and expiry of a register
+func (s *Signer) Token(status *nodev1alpha1.RegisterStatus) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(status.UUID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(status.ConfigURLNonce))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expiryUnix(status), 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a token was issued for the current nonce and expiry of a register,
+// and has not yet expired
+func (s *Signer) Verify(status *nodev1alpha1.RegisterStatus, token string) error {
+	if !hmac.Equal([]byte(s.Token(status)), []byte(token)) {
+		return ErrInvalidToken
+	}
+
+	if Expired(status) {
+		return ErrExpiredToken
+	}
+
+	return nil
+}
+
+// Expired checks if the config url of a register has expired
+func Expired(status *nodev1alpha1.RegisterStatus) bool {
+	return status.ConfigURLExpiry != nil && time.Now().After(status.ConfigURLExpiry.Time)
+}
+
+// Rotate generates a new nonce, invalidating previously issued tokens, and sets a
+// new expiry if a ttl is specified
+func Rotate(status *nodev1alpha1.RegisterStatus, ttl time.Duration) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "error generating nonce")
+	}
+	status.ConfigURLNonce = hex.EncodeToString(nonce)
+
+	status.ConfigURLExpiry = nil
+	if ttl > 0 {
+		expiry := metav1.NewTime(time.Now().Add(ttl).Truncate(time.Second))
+		status.ConfigURLExpiry = &expiry
+	}
+	return nil
+}
+
+func expiryUnix(status *nodev1alpha1.RegisterStatus) int64 {
+	if status.ConfigURLExpiry == nil {
+		return 0
+	}
+	return status.ConfigURLExpiry.Unix()
+}
@@ -0,0 +1,96 @@
+package configurl
+
+import (
+	"testing"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestVerify(t *testing.T) {
+	signer := NewSigner([]byte("0123456789abcdef0123456789abcdef"))
+
+	tests := []struct {
+		name string
+		// ttl is used to rotate the nonce of the status the token is issued for
+		ttl time.Duration
+		// mutate changes the status after the token is issued
+		mutate func(status *nodev1alpha1.RegisterStatus)
+		// signAfter issues the token after the status is changed
+		signAfter bool
+		// noToken verifies an empty token, as sent by a url without a token
+		noToken bool
+		wantErr error
+	}{
+		{
+			name: "valid token",
+		},
+		{
+			name: "valid token before expiry",
+			ttl:  time.Hour,
+		},
+		{
+			name: "nonce rotated",
+			mutate: func(status *nodev1alpha1.RegisterStatus) {
+				if err := Rotate(status, 0); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "expired",
+			ttl:  time.Hour,
+			mutate: func(status *nodev1alpha1.RegisterStatus) {
+				status.ConfigURLExpiry.Time = status.ConfigURLExpiry.Add(-2 * time.Hour)
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name: "expired token for the expiry",
+			mutate: func(status *nodev1alpha1.RegisterStatus) {
+				expiry := metav1.NewTime(time.Now().Add(-time.Minute).Truncate(time.Second))
+				status.ConfigURLExpiry = &expiry
+			},
+			signAfter: true,
+			wantErr:   ErrExpiredToken,
+		},
+		{
+			name: "different uuid",
+			mutate: func(status *nodev1alpha1.RegisterStatus) {
+				status.UUID = "f2a3e1a4-7f0c-4f6b-9a64-0d1a7e0c2b57"
+			},
+			wantErr: ErrInvalidToken,
+		},
+		{
+			name:    "no token",
+			noToken: true,
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := &nodev1alpha1.RegisterStatus{UUID: "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e"}
+			if err := Rotate(status, tt.ttl); err != nil {
+				t.Fatal(err)
+			}
+
+			token := signer.Token(status)
+			if tt.mutate != nil {
+				tt.mutate(status)
+			}
+			if tt.signAfter {
+				token = signer.Token(status)
+			}
+			if tt.noToken {
+				token = ""
+			}
+
+			if err := signer.Verify(status, token); err != tt.wantErr {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
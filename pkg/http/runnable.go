@@ -0,0 +1,101 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+)
+
+// Server runs the config server as a manager.Runnable. It is started once the caches
+// have synced, and drains in-flight requests for up to ShutdownTimeout when stopped
+type Server struct {
+	Addr    string
+	Handler http.Handler
+	// TLSConfig serves https when specified
+	TLSConfig       *tls.Config
+	ShutdownTimeout time.Duration
+	Log             logr.Logger
+
+	serving int32
+	mu      sync.Mutex
+	done    chan struct{}
+}
+
+// Start implements manager.Runnable
+func (s *Server) Start(stop <-chan struct{}) error {
+	s.mu.Lock()
+	done := make(chan struct{})
+	s.done = done
+	s.mu.Unlock()
+	defer close(done)
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return errors.Wrapf(err, "config server unable to listen on %s", s.Addr)
+	}
+
+	if s.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.TLSConfig)
+	}
+
+	srv := &http.Server{
+		Handler:   s.Handler,
+		TLSConfig: s.TLSConfig,
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(listener)
+	}()
+	atomic.StoreInt32(&s.serving, 1)
+	s.Log.Info("config server listening", "addr", s.Addr, "tls", s.TLSConfig != nil)
+
+	select {
+	case <-stop:
+		atomic.StoreInt32(&s.serving, 0)
+		s.Log.Info("shutting down config server", "addr", s.Addr)
+		ctx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			return errors.Wrap(err, "error shutting down config server")
+		}
+		return nil
+	case err := <-serveErr:
+		atomic.StoreInt32(&s.serving, 0)
+		if err == http.ErrServerClosed {
+			return nil
+		}
+		return errors.Wrapf(err, "config server on %s failed", s.Addr)
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. Nodes can fetch
+// their config from any replica, so the server runs on all of them
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// ReadyCheck implements healthz.Checker, and fails when the server is not serving
+func (s *Server) ReadyCheck(_ *http.Request) error {
+	if atomic.LoadInt32(&s.serving) == 0 {
+		return errors.Errorf("config server not serving on %s", s.Addr)
+	}
+	return nil
+}
+
+// Wait blocks until the server has shut down, or returns immediately if it was never started
+func (s *Server) Wait() {
+	s.mu.Lock()
+	done := s.done
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+}
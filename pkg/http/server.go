@@ -3,32 +3,65 @@ package http
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
-	"strings"
+	"time"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
 
 	"github.com/ghodss/yaml"
 	"github.com/go-logr/logr"
 	"github.com/gorilla/mux"
 	"github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/configurl"
 	installer "github.com/ibrokethecloud/harvester-tink-operator/pkg/installer"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/metrics"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/tink"
 	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
+	"github.com/tinkerbell/tink/protos/hardware"
+	corev1 "k8s.io/api/core/v1"
 	apierror "k8s.io/apimachinery/pkg/api/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+
 type ConfigServer struct {
 	client.Client
-	Log logr.Logger
+	// APIReader is used to read the token and password secrets without caching all secrets
+	APIReader client.Reader
+	Log       logr.Logger
+	Recorder  record.EventRecorder
+	// Signer verifies the tokens in the config urls
+	Signer *configurl.Signer
+	// SingleUse rotates the config url after the config is served, with a new expiry of ConfigURLTTL
+	SingleUse    bool
+	ConfigURLTTL time.Duration
+	// BindClientIP only serves the config to the ips in the tink dhcp records of the hardware
+	BindClientIP bool
+	Tink         *tink.Connection
 }
 
 func (c *ConfigServer) SetupRoutes(r *mux.Router) {
+	r.HandleFunc("/config/{uuid}/{token}", c.getConfig).Methods("GET")
+	// urls without a token are rejected, but are still routed to report the error //
 	r.HandleFunc("/config/{uuid}", c.getConfig).Methods("GET")
 	c.Log.Info("adding config route")
 }
 
-func (c *ConfigServer) getConfig(w http.ResponseWriter, r *http.Request) {
+func (c *ConfigServer) getConfig(rw http.ResponseWriter, r *http.Request) {
+	w := &statusWriter{ResponseWriter: rw, status: http.StatusOK}
+	found := false
+	defer func() {
+		metrics.ObserveConfigRequest(found, w.status)
+	}()
+
 	vars := mux.Vars(r)
 	configUUID, ok := vars["uuid"]
 	if !ok {
@@ -59,98 +92,98 @@ func (c *ConfigServer) getConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	node := nodeList.Items[0]
+	found = true
+
+	if err := c.authorize(&node, vars["token"], requestIP(r)); err != nil {
+		c.Log.Info("rejecting config request", "name", node.Name, "ip", requestIP(r), "reason", err.Error())
+		c.Recorder.Eventf(&node, corev1.EventTypeWarning, "ConfigRequestRejected", "config request from %s rejected: %v", requestIP(r), err)
+		util.ReturnHTTPMessage(w, r, 403, "error", err.Error())
+		return
+	}
 
 	// check if node is already registered in which case disable serving the url //
 	if _, ok := node.Labels["nodeReady"]; ok {
 		util.ReturnHTTPMessage(w, r, 200, "info", "node already processed")
 		return
 	}
-	serverURL, err := util.FetchServerURL(c.Client)
+	cfg := config.Get()
+	joinURL, err := util.JoinServerURL(c.Client)
 	if err != nil {
 		util.ReturnHTTPMessage(w, r, 500, "error", "server-url fetch error")
 		return
 	}
 
-	serverURLArr := strings.Split(serverURL, ":")
 	os := installer.OS{
-		Hostname: node.Name,
+		Hostname: node.Hostname(),
 	}
 
 	if len(node.Spec.SSHAuthorizedKeys) != 0 {
 		os.SSHAuthorizedKeys = node.Spec.SSHAuthorizedKeys
 	}
 
-	if len(node.Spec.Password) != 0 {
-		os.Password = node.Spec.Password
-	} else {
-		os.Password = node.Name
+	token, err := c.resolveToken(&node)
+	if err != nil {
+		c.renderFailed(w, r, &node, err)
+		return
 	}
 
-	if len(node.Spec.NTPServers) != 0 {
-		os.NTPServers = node.Spec.NTPServers
+	os.Password, err = c.resolvePassword(&node)
+	if err != nil {
+		c.renderFailed(w, r, &node, err)
+		return
 	}
 
-	if len(node.Spec.DNSNameservers) != 0 {
-		os.DNSNameservers = node.Spec.NTPServers
+	if len(node.Spec.NTPServers) != 0 {
+		os.NTPServers = node.Spec.NTPServers
 	}
 
 	if len(node.Spec.Environment) != 0 {
 		os.Environment = node.Spec.Environment
 	}
 
-	network := installer.Network{
-		Interfaces: []installer.NetworkInterface{
-			installer.NetworkInterface{
-				Name:   node.Spec.Interface,
-				HwAddr: node.Spec.MacAddress,
-			},
-		},
-	}
-
-	/*if len(node.Spec.Address) != 0 && len(node.Spec.Netmask) != 0 && len(node.Spec.Gateway) != 0 {
-		network.IP = node.Spec.Address
-		network.SubnetMask = node.Spec.Netmask
-		network.Gateway = node.Spec.Gateway
-	} */
-	network.DefaultRoute = true
-	network.Method = "dhcp"
-
-	if len(node.Spec.NTPServers) != 0 {
-		os.NTPServers = node.Spec.NTPServers
+	networks, err := util.GenerateNetworks(&node.Spec)
+	if err != nil {
+		util.ReturnHTTPMessage(w, r, 500, "error", err.Error())
+		return
 	}
 
 	if len(node.Spec.DNSNameservers) != 0 {
 		os.DNSNameservers = node.Spec.DNSNameservers
+	} else if len(node.Spec.Nameservers) != 0 {
+		os.DNSNameservers = node.Spec.Nameservers
 	}
 
-	disk := "/dev/sda"
+	disk := cfg.DefaultDisk
 	if len(node.Spec.Disk) != 0 {
 		disk = node.Spec.Disk
 	}
-	harvesterManagementNetwork := make(map[string]installer.Network)
-	harvesterManagementNetwork["harvester-mgmt"] = network
 	install := installer.Install{
-		Networks:  harvesterManagementNetwork,
+		Networks:  networks,
 		Automatic: true,
 		Mode:      "join",
 		Device:    disk,
 	}
 
-	version, err := util.FindHarvesterVersion(c.Client)
-	if err != nil {
-		util.ReturnHTTPMessage(w, r, 500, "error", "harvester version fetch error")
-		return
-	}
-
 	if len(node.Spec.PXEIsoURL) != 0 {
 		install.ISOURL = node.Spec.PXEIsoURL
 	} else {
-		install.ISOURL = fmt.Sprintf("https://releases.rancher.com/harvester/%s/harvester-%s-amd64.iso", version, version)
+		version, err := util.FindHarvesterVersion(c.Client)
+		switch {
+		case err == nil:
+			install.ISOURL = util.GenerateISOURL(version)
+		case len(cfg.DefaultISOURL) != 0:
+			c.Log.Error(err, "unable to find harvester version, using default iso url", "name", node.Name)
+			install.ISOURL = cfg.DefaultISOURL
+		default:
+			c.Log.Error(err, "unable to find harvester version", "name", node.Name)
+			util.ReturnHTTPMessage(w, r, 500, "error", "harvester version fetch error")
+			return
+		}
 	}
 
 	config := installer.HarvesterConfig{
-		ServerURL: strings.Join(append([]string{"https"}, serverURLArr[1:len(serverURLArr)-1]...), ":") + ":8443",
-		Token:     node.Spec.Token,
+		ServerURL: joinURL,
+		Token:     token,
 		OS:        os,
 		Install:   install,
 	}
@@ -162,4 +195,183 @@ func (c *ConfigServer) getConfig(w http.ResponseWriter, r *http.Request) {
 	}
 
 	util.ReturnHTTPRaw(w, r, string(contentByte))
+
+	if err := c.recordConfigFetch(node.Name, requestIP(r)); err != nil {
+		c.Log.Error(err, "unable to update register status", "name", node.Name)
+	}
+}
+
+// authorize verifies the token in the config url, and if enabled that the request
+// is made from an ip in the tink dhcp records of the hardware //
+func (c *ConfigServer) authorize(node *v1alpha1.Register, token string, ip string) error {
+	if len(token) == 0 {
+		return fmt.Errorf("config url token required")
+	}
+
+	if err := c.Signer.Verify(&node.Status, token); err != nil {
+		return err
+	}
+
+	if !c.BindClientIP {
+		return nil
+	}
+
+	hardwareClient, err := c.Tink.HardwareClient()
+	if err != nil {
+		return fmt.Errorf("unable to verify client ip: %v", err)
+	}
+
+	current, err := hardwareClient.ByID(context.Background(), &hardware.GetRequest{Id: node.Status.UUID})
+	if err != nil {
+		return fmt.Errorf("unable to fetch hardware to verify client ip: %v", err)
+	}
+
+	var addresses []string
+	for _, nic := range current.GetNetwork().GetInterfaces() {
+		if address := nic.GetDhcp().GetIp().GetAddress(); len(address) != 0 {
+			addresses = append(addresses, address)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return fmt.Errorf("no ip address in tink dhcp records to bind the config url to")
+	}
+
+	if !containsString(addresses, ip) {
+		return fmt.Errorf("client ip %s does not match tink dhcp records %v", ip, addresses)
+	}
+
+	return nil
+}
+
+// resolveToken returns the join token from the token secret if referenced, else from the spec.
+// If neither is specified the join token of the harvester cluster is used //
+func (c *ConfigServer) resolveToken(node *v1alpha1.Register) (token string, err error) {
+	// registers may predate the namespace restriction, or the webhook may be disabled //
+	if node.Spec.TokenSecretRef != nil {
+		if err := util.ValidateSecretRef("tokenSecretRef", node.Spec.TokenSecretRef); err != nil {
+			return token, err
+		}
+		return util.GetSecretValue(c.APIReader, node.Spec.TokenSecretRef)
+	}
+
+	if len(node.Spec.Token) != 0 {
+		return node.Spec.Token, nil
+	}
+
+	token, err = util.FindClusterToken(c.APIReader)
+	if err != nil {
+		return token, fmt.Errorf("no token or tokenSecretRef specified, and unable to find cluster token: %v", err)
+	}
+	return token, nil
+}
+
+// resolvePassword returns the os password from the password secret if referenced, else from the spec.
+// The hostname is only used as the password when explicitly allowed //
+func (c *ConfigServer) resolvePassword(node *v1alpha1.Register) (password string, err error) {
+	switch {
+	case node.Spec.PasswordSecretRef != nil:
+		if err := util.ValidateSecretRef("passwordSecretRef", node.Spec.PasswordSecretRef); err != nil {
+			return password, err
+		}
+		password, err = util.GetSecretValue(c.APIReader, node.Spec.PasswordSecretRef)
+		if err != nil {
+			return password, err
+		}
+	case len(node.Spec.Password) != 0:
+		password = node.Spec.Password
+	case node.Spec.AllowHostnamePassword:
+		return node.Hostname(), nil
+	default:
+		return password, fmt.Errorf("no password or passwordSecretRef specified, and allowHostnamePassword is false")
+	}
+
+	return password, util.ValidatePassword(password)
+}
+
+// renderFailed reports config generation errors to the client and on the register //
+func (c *ConfigServer) renderFailed(w http.ResponseWriter, r *http.Request, node *v1alpha1.Register, err error) {
+	c.Log.Error(err, "unable to generate config", "name", node.Name)
+	c.Recorder.Eventf(node, corev1.EventTypeWarning, "ConfigGenerationFailed", "%v", err)
+	util.ReturnHTTPMessage(w, r, 500, "error", "error during config generation")
+}
+
+// recordConfigFetch records the config fetch on the register status, and flags
+// fetches from an ip other than the one expected for the node //
+func (c *ConfigServer) recordConfigFetch(name string, ip string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node := &v1alpha1.Register{}
+		if err := c.Get(context.Background(), types.NamespacedName{Name: name}, node); err != nil {
+			return err
+		}
+
+		now := metav1.Now()
+		fetch := node.Status.ConfigFetch
+		if fetch == nil {
+			fetch = &v1alpha1.ConfigFetchStatus{
+				FirstFetchTime: now,
+				ExpectedIP:     node.Spec.Address,
+			}
+			if len(fetch.ExpectedIP) == 0 {
+				fetch.ExpectedIP = ip
+			}
+		}
+		fetch.Count++
+		fetch.LastFetchTime = now
+		fetch.LastFetchIP = ip
+
+		unexpected := ip != fetch.ExpectedIP
+		if unexpected && !containsString(fetch.UnexpectedIPs, ip) {
+			fetch.UnexpectedIPs = append(fetch.UnexpectedIPs, ip)
+		}
+		node.Status.ConfigFetch = fetch
+		if c.SingleUse {
+			if err := configurl.Rotate(&node.Status, c.ConfigURLTTL); err != nil {
+				return err
+			}
+		}
+		node.Status.SetCondition(v1alpha1.ConditionConfigServed, metav1.ConditionTrue, "ConfigFetched",
+			fmt.Sprintf("config fetched %d times, last from %s", fetch.Count, ip))
+
+		if err := c.Status().Update(context.Background(), node); err != nil {
+			return err
+		}
+
+		if unexpected {
+			c.Recorder.Eventf(node, corev1.EventTypeWarning, "UnexpectedConfigFetch",
+				"config fetched from %s, expected %s", ip, fetch.ExpectedIP)
+		} else {
+			c.Recorder.Eventf(node, corev1.EventTypeNormal, "ConfigFetched", "config fetched from %s", ip)
+		}
+		return nil
+	})
+}
+
+// statusWriter captures the status code written to the response //
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// requestIP returns the ip of the client making the request //
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
 }
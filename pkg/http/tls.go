@@ -0,0 +1,67 @@
+package http
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	TLSCertFile = "tls.crt"
+	TLSKeyFile  = "tls.key"
+)
+
+// CertReloader serves the certificate from a directory, reloading it when the files
+// change so certificates rotated in the mounted secret are picked up without a restart
+type CertReloader struct {
+	CertDir string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// GetCertificate implements tls.Config GetCertificate
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.cert == nil || modTime.After(c.modTime) {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(c.CertDir, TLSCertFile), filepath.Join(c.CertDir, TLSKeyFile))
+		if err != nil {
+			return nil, errors.Wrap(err, "error loading config server certificate")
+		}
+		c.cert = &cert
+		c.modTime = modTime
+	}
+
+	return c.cert, nil
+}
+
+// Validate ensures the certificate can be loaded, so errors are reported at startup
+func (c *CertReloader) Validate() error {
+	_, err := c.GetCertificate(nil)
+	return err
+}
+
+func (c *CertReloader) latestModTime() (modTime time.Time, err error) {
+	for _, file := range []string{TLSCertFile, TLSKeyFile} {
+		info, err := os.Stat(filepath.Join(c.CertDir, file))
+		if err != nil {
+			return modTime, errors.Wrap(err, "error reading config server certificate")
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
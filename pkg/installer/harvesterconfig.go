@@ -27,6 +27,24 @@ const (
 	BondModeBalanceALB   = "balance-alb"
 )
 
+// ValidBondMode checks if mode is one of the bond modes supported by the installer
+func ValidBondMode(mode string) bool {
+	switch mode {
+	case BondModeBalanceRR, BondModeActiveBackup, BondModeBalnaceXOR, BondModeBroadcast,
+		BondModeIEEE802_3ad, BondModeBalanceTLB, BondModeBalanceALB:
+		return true
+	}
+	return false
+}
+
+const (
+	NetworkMethodDHCP   = "dhcp"
+	NetworkMethodStatic = "static"
+	NetworkMethodNone   = "none"
+
+	ManagementNetworkName = "harvester-mgmt"
+)
+
 type Network struct {
 	Interfaces   []NetworkInterface `json:"interfaces,omitempty"`
 	Method       string             `json:"method,omitempty"`
@@ -35,6 +53,8 @@ type Network struct {
 	Gateway      string             `json:"gateway,omitempty"`
 	DefaultRoute bool               `json:"defaultRoute,omitempty"`
 	BondOptions  map[string]string  `json:"bondOptions,omitempty"`
+	VlanID       int                `json:"vlanId,omitempty"`
+	MTU          int                `json:"mtu,omitempty"`
 }
 
 type HTTPBasicAuth struct {
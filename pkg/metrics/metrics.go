@@ -0,0 +1,167 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	namespace = "harvester_tink_operator"
+
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	OperationPush   = "push"
+	OperationDelete = "delete"
+)
+
+var (
+	tinkRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tink_requests_total",
+		Help:      "Number of hardware requests made to tink, by operation and result",
+	}, []string{"operation", "result"})
+
+	tinkRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "tink_request_duration_seconds",
+		Help:      "Duration of hardware requests made to tink, by operation",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	configRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "config_requests_total",
+		Help:      "Number of requests to the config server, by uuid lookup result and status code",
+	}, []string{"uuid", "code"})
+
+	orphanedHardware = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "orphaned_hardware",
+		Help:      "Number of hardware records in tink with no matching Register, as of the last hardware sync",
+	})
+
+	tinkConnected = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tink_connected",
+		Help:      "Whether the operator has a usable connection to tink",
+	})
+
+	provisioningFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provisioning_failures_total",
+		Help:      "Number of Registers which failed to provision within the provisioning timeout, by reason",
+	}, []string{"reason"})
+
+	nodeJoinDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "node_join_duration_seconds",
+		Help:      "Time from Register creation, or the last reprovision, until the node joined the cluster",
+		Buckets:   []float64{60, 300, 600, 900, 1200, 1800, 2700, 3600, 7200},
+	})
+)
+
+// Register adds the operator metrics to the controller-runtime registry, which is
+// served on the manager metrics endpoint
+func Register(apiClient client.Client) {
+	ctrlmetrics.Registry.MustRegister(
+		tinkRequests,
+		tinkRequestDuration,
+		configRequests,
+		nodeJoinDuration,
+		provisioningFailures,
+		orphanedHardware,
+		tinkConnected,
+		&phaseCollector{client: apiClient},
+	)
+}
+
+// ObserveTinkRequest records the result and duration of a tink hardware request
+func ObserveTinkRequest(operation string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	tinkRequests.WithLabelValues(operation, result).Inc()
+	tinkRequestDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveConfigRequest records a request to the config server
+func ObserveConfigRequest(found bool, code int) {
+	uuid := "miss"
+	if found {
+		uuid = "hit"
+	}
+	configRequests.WithLabelValues(uuid, strconv.Itoa(code)).Inc()
+}
+
+// ObserveNodeJoined records the time taken for a registered or reprovisioned node to join the cluster
+func ObserveNodeJoined(started time.Time) {
+	nodeJoinDuration.Observe(time.Since(started).Seconds())
+}
+
+// ObserveProvisioningFailure records a Register failing to provision
+func ObserveProvisioningFailure(reason string) {
+	provisioningFailures.WithLabelValues(reason).Inc()
+}
+
+// SetOrphanedHardware records the number of orphaned hardware records found in tink
+func SetOrphanedHardware(count int) {
+	orphanedHardware.Set(float64(count))
+}
+
+// SetTinkConnected records whether the connection to tink is usable
+func SetTinkConnected(connected bool) {
+	if connected {
+		tinkConnected.Set(1)
+	} else {
+		tinkConnected.Set(0)
+	}
+}
+
+var phaseDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "registers"),
+	"Number of Registers in each phase",
+	[]string{"phase"}, nil,
+)
+
+// phaseCollector counts Registers per phase on each scrape, using the cached client
+type phaseCollector struct {
+	client client.Client
+}
+
+func (p *phaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- phaseDesc
+}
+
+func (p *phaseCollector) Collect(ch chan<- prometheus.Metric) {
+	registerList := &nodev1alpha1.RegisterList{}
+	if err := p.client.List(context.Background(), registerList); err != nil {
+		return
+	}
+
+	phases := map[string]float64{
+		"":                         0,
+		nodev1alpha1.UIDGenerated:  0,
+		nodev1alpha1.HWPushed:      0,
+		nodev1alpha1.NodeProcessed: 0,
+		nodev1alpha1.Failed:        0,
+		nodev1alpha1.Adopted:       0,
+	}
+	for _, register := range registerList.Items {
+		phases[register.Status.Status]++
+	}
+
+	for phase, count := range phases {
+		if phase == "" {
+			phase = "pending"
+		}
+		ch <- prometheus.MustNewConstMetric(phaseDesc, prometheus.GaugeValue, count, phase)
+	}
+}
@@ -0,0 +1,97 @@
+package tink
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/installer"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
+	"github.com/tinkerbell/tink/protos/hardware"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	AdoptedAnnotation = "node.harvesterci.io/adopted-from-tink"
+)
+
+// RegisterFromHardware generates a Register from existing tink hardware. The tink id is
+// set as the uuid label, and the adopted annotation keeps the reconciler from pushing over
+// the existing hardware until a reprovision is requested
+func RegisterFromHardware(hw *hardware.Hardware) (regoReq *nodev1alpha1.Register, err error) {
+	interfaces := hw.GetNetwork().GetInterfaces()
+	if len(interfaces) == 0 || interfaces[0].GetDhcp() == nil {
+		return nil, fmt.Errorf("hardware %s has no dhcp interfaces", hw.Id)
+	}
+
+	dhcp := interfaces[0].GetDhcp()
+	regoReq = &nodev1alpha1.Register{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: registerName(hw.Id, dhcp.Hostname),
+			Labels: map[string]string{
+				"uuid": hw.Id,
+			},
+			Annotations: map[string]string{
+				AdoptedAnnotation: "true",
+			},
+		},
+		Spec: nodev1alpha1.RegisterSpec{
+			MacAddress:     dhcp.Mac,
+			Interface:      dhcp.IfaceName,
+			DNSNameservers: dhcp.NameServers,
+			NTPServers:     dhcp.TimeServers,
+		},
+	}
+
+	// keep the tink hostname when the name had to be generated, so the node is not installed with it //
+	if hostname := strings.ToLower(dhcp.Hostname); len(hostname) != 0 && hostname != regoReq.Name {
+		regoReq.Spec.Hostname = hostname
+	}
+
+	// tink records often have an address without a gateway, which is left to dhcp rather than
+	// adopting a partial static address the register would be rejected for //
+	ip := dhcp.GetIp()
+	if static, err := util.ValidateStaticAddress(ip.GetAddress(), ip.GetNetmask(), ip.GetGateway()); err == nil && static {
+		regoReq.Spec.Address = ip.Address
+		regoReq.Spec.Netmask = ip.Netmask
+		regoReq.Spec.Gateway = ip.Gateway
+	}
+
+	if osie := interfaces[0].GetNetboot().GetOsie(); osie != nil {
+		regoReq.Spec.ImageURL = osie.BaseUrl
+	}
+
+	if len(interfaces) > 1 {
+		mgmtNetwork := &nodev1alpha1.ManagementNetwork{}
+		for _, nic := range interfaces {
+			mgmtNetwork.Interfaces = append(mgmtNetwork.Interfaces, installer.NetworkInterface{
+				Name:   nic.GetDhcp().GetIfaceName(),
+				HwAddr: nic.GetDhcp().GetMac(),
+			})
+		}
+		regoReq.Spec.ManagementNetwork = mgmtNetwork
+	}
+
+	metadata := &nodev1alpha1.MetaData{}
+	if err := json.Unmarshal([]byte(hw.Metadata), metadata); err == nil {
+		for _, slug := range nodev1alpha1.SupportedSlugs {
+			if metadata.Instance.OperatingSystem.Slug == slug {
+				regoReq.Spec.Slug = slug
+			}
+		}
+	}
+
+	return regoReq, nil
+}
+
+// registerName uses the dhcp hostname if it is a valid object name, else generates
+// a name from the tink id
+func registerName(id string, hostname string) string {
+	name := strings.ToLower(hostname)
+	if len(name) != 0 && len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+	return "tink-" + strings.ToLower(id)
+}
@@ -0,0 +1,62 @@
+package tink
+
+import (
+	"testing"
+
+	"github.com/tinkerbell/tink/protos/hardware"
+)
+
+func TestRegisterFromHardwareAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		ip          *hardware.Hardware_DHCP_IP
+		wantAddress string
+	}{
+		{
+			name: "no ip",
+		},
+		{
+			name: "empty ip",
+			ip:   &hardware.Hardware_DHCP_IP{},
+		},
+		{
+			name: "address without a gateway",
+			ip:   &hardware.Hardware_DHCP_IP{Address: "172.16.128.11", Netmask: "255.255.248.0"},
+		},
+		{
+			name: "address outside the gateway subnet",
+			ip:   &hardware.Hardware_DHCP_IP{Address: "172.16.136.11", Netmask: "255.255.248.0", Gateway: "172.16.128.1"},
+		},
+		{
+			name:        "static address",
+			ip:          &hardware.Hardware_DHCP_IP{Address: "172.16.128.11", Netmask: "255.255.248.0", Gateway: "172.16.128.1"},
+			wantAddress: "172.16.128.11",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hw := &hardware.Hardware{
+				Id: "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e",
+				Network: &hardware.Hardware_Network{
+					Interfaces: []*hardware.Hardware_Network_Interface{
+						{Dhcp: &hardware.Hardware_DHCP{Mac: "0c:c4:7a:6b:84:20", Hostname: "node1", Ip: tt.ip}},
+					},
+				},
+			}
+
+			regoReq, err := RegisterFromHardware(hw)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			spec := regoReq.Spec
+			if spec.Address != tt.wantAddress {
+				t.Errorf("RegisterFromHardware() address = %q, want %q", spec.Address, tt.wantAddress)
+			}
+			if len(tt.wantAddress) == 0 && (len(spec.Netmask) != 0 || len(spec.Gateway) != 0) {
+				t.Errorf("RegisterFromHardware() netmask = %q, gateway = %q, want neither without an address", spec.Netmask, spec.Gateway)
+			}
+		})
+	}
+}
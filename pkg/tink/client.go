@@ -3,15 +3,19 @@ package tink
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/url"
-	"strings"
+	"reflect"
 	"text/template"
 
 	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
 
 	"github.com/tinkerbell/tink/protos/hardware"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/pkg/errors"
 	hw "github.com/tinkerbell/tink/client"
 	corev1 "k8s.io/api/core/v1"
@@ -19,84 +23,89 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func NewClient(apiClient client.Client) (fullClient *hw.FullClient, err error) {
+// ConnOptions reads the tink endpoints from the tink configmap
+func ConnOptions(apiClient client.Reader) (connOpts *hw.ConnOptions, err error) {
 	var certURL, grpcAuth string
+	cfg := config.Get()
 	cm := &corev1.ConfigMap{}
-	err = apiClient.Get(context.Background(), types.NamespacedName{Name: nodev1alpha1.ConfigMapName, Namespace: nodev1alpha1.ConfigMapNamespace}, cm)
+	err = apiClient.Get(context.Background(), types.NamespacedName{Name: cfg.TinkConfigMapName, Namespace: cfg.TinkConfigMapNamespace}, cm)
 	if err != nil {
 		return nil, errors.Wrap(err, "error during configMap get")
 	}
 
 	certURL, ok := cm.Data["CERT_URL"]
 	if !ok {
-		return nil, fmt.Errorf("cert_url not found in configmap tinkConfig")
+		return nil, fmt.Errorf("cert_url not found in configmap %s", cfg.TinkConfigMapName)
 	}
 
 	grpcAuth, ok = cm.Data["GRPC_AUTH_URL"]
 	if !ok {
-		return nil, fmt.Errorf("grpc_auth_url not found in configmap tinkConfig")
+		return nil, fmt.Errorf("grpc_auth_url not found in configmap %s", cfg.TinkConfigMapName)
 	}
 
-	connOpts := &hw.ConnOptions{CertURL: certURL, GRPCAuthority: grpcAuth}
-
-	grpcConn, err := hw.NewClientConn(connOpts)
-	if err != nil {
-		return nil, errors.Wrap(err, "error creating grpc clients")
-	}
-
-	fullClient = hw.NewFullClient(grpcConn)
-
-	return fullClient, err
+	return &hw.ConnOptions{CertURL: certURL, GRPCAuthority: grpcAuth}, nil
 }
 
-func GenerateHWRequest(regoReq *nodev1alpha1.Register, serverURL string) (hw *hardware.Hardware, err error) {
+// GenerateHWRequest generates the tink hardware for a register. The token is embedded in
+// the config url, and is verified by the config server. Netboot is disabled once the node
+// is installed, so a node which boots from the network first is not reinstalled on reboot
+func GenerateHWRequest(regoReq *nodev1alpha1.Register, serverURL string, token string, allowPxe bool) (hw *hardware.Hardware, err error) {
 
-	networkInterfaces := &hardware.Hardware_Network_Interface{
-		Netboot: &hardware.Hardware_Netboot{
-			AllowPxe: true,
-		},
+	static, err := util.ValidateStaticAddress(regoReq.Spec.Address, regoReq.Spec.Netmask, regoReq.Spec.Gateway)
+	if err != nil {
+		return nil, errors.Wrap(err, "error validating static address")
 	}
 
-	// Specify non default location to load ISO's
-	if len(regoReq.Spec.ImageURL) != 0 {
-		networkInterfaces.Netboot.Osie = &hardware.Hardware_Netboot_Osie{BaseUrl: regoReq.Spec.ImageURL}
-	}
+	var networkInterfaces []*hardware.Hardware_Network_Interface
+	// each member of the management network gets its own dhcp record, allowing any nic to pxe boot //
+	for _, nic := range util.ManagementInterfaces(&regoReq.Spec) {
+		if len(nic.HwAddr) == 0 {
+			return nil, fmt.Errorf("no hwAddr specified for interface %s", nic.Name)
+		}
+
+		networkInterface := &hardware.Hardware_Network_Interface{
+			Netboot: &hardware.Hardware_Netboot{
+				AllowPxe:      allowPxe,
+				AllowWorkflow: false,
+			},
+		}
 
-	ip := &hardware.Hardware_DHCP_IP{}
+		// Specify non default location to load ISO's
+		if len(regoReq.Spec.ImageURL) != 0 {
+			networkInterface.Netboot.Osie = &hardware.Hardware_Netboot_Osie{BaseUrl: regoReq.Spec.ImageURL}
+		}
 
-	dhcpRequest := &hardware.Hardware_DHCP{
-		Mac:      regoReq.Spec.MacAddress,
-		Ip:       ip,
-		Hostname: regoReq.Name,
-	}
+		ip := &hardware.Hardware_DHCP_IP{}
 
-	if len(regoReq.Spec.Address) != 0 && len(regoReq.Spec.Gateway) != 0 && len(regoReq.Spec.Netmask) != 0 {
-		ip.Address = regoReq.Spec.Address
-		ip.Gateway = regoReq.Spec.Gateway
-		ip.Netmask = regoReq.Spec.Netmask
+		if static {
+			ip.Address = regoReq.Spec.Address
+			ip.Gateway = regoReq.Spec.Gateway
+			ip.Netmask = regoReq.Spec.Netmask
+		}
 
-	}
+		// update dhcp request
+		networkInterface.Dhcp = &hardware.Hardware_DHCP{
+			Mac:      nic.HwAddr,
+			Ip:       ip,
+			Hostname: regoReq.Hostname(),
+		}
 
-	// update dhcp request
-	networkInterfaces.Dhcp = dhcpRequest
+		networkInterfaces = append(networkInterfaces, networkInterface)
+	}
 
 	url, err := url.Parse(serverURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "error parsing server url")
 	}
 
-	urlArr := strings.Split(url.Host, ":")
-
-	m, err := generateMetaData(regoReq, urlArr[0])
+	m, err := generateMetaData(regoReq, url, token)
 	if err != nil {
 		return hw, errors.Wrap(err, "error during metadata generation")
 	}
 	hw = &hardware.Hardware{
 		Id: regoReq.Status.UUID,
 		Network: &hardware.Hardware_Network{
-			Interfaces: []*hardware.Hardware_Network_Interface{
-				networkInterfaces,
-			},
+			Interfaces: networkInterfaces,
 		},
 		Metadata: m,
 	}
@@ -104,29 +113,36 @@ func GenerateHWRequest(regoReq *nodev1alpha1.Register, serverURL string) (hw *ha
 	return hw, nil
 }
 
-func generateMetaData(regoReq *nodev1alpha1.Register, serverURL string) (metadata string, err error) {
+func generateMetaData(regoReq *nodev1alpha1.Register, serverURL *url.URL, token string) (metadata string, err error) {
 
 	var tmpStruct struct {
+		Scheme        string
 		ServerUrl     string
 		DefaultPort   string
 		UUID          string
+		Token         string
 		Slug          string
 		Interface     string
 		BootArguments string
 	}
 	var output bytes.Buffer
-	tmpStruct.ServerUrl = serverURL
-	tmpStruct.DefaultPort = nodev1alpha1.DefaultConfigURLPort
+	tmpStruct.Scheme = serverURL.Scheme
+	tmpStruct.ServerUrl = serverURL.Hostname()
+	tmpStruct.DefaultPort = serverURL.Port()
+	if len(tmpStruct.DefaultPort) == 0 {
+		tmpStruct.DefaultPort = config.Get().ConfigURLPort
+	}
 	tmpStruct.UUID = regoReq.Status.UUID
+	tmpStruct.Token = token
 	if regoReq.Spec.Slug != "" {
 		tmpStruct.Slug = regoReq.Spec.Slug
 	} else {
-		tmpStruct.Slug = nodev1alpha1.DefaultSlug
+		tmpStruct.Slug = config.Get().DefaultSlug
 	}
 
 	tmpStruct.Interface = regoReq.Spec.Interface
 	tmpStruct.BootArguments = regoReq.Spec.KernelBootArguments
-	var metaDataStruct = `{"facility":{"facility_code":"onprem"},"instance":{"userdata":"harvester.install.config_url=http://{{ .ServerUrl }}:{{ .DefaultPort }}/config/{{ .UUID }} {{ .BootArguments }}" ,"operating_system":{"slug":"{{ .Slug }}"}}}`
+	var metaDataStruct = `{"facility":{"facility_code":"onprem"},"instance":{"userdata":"harvester.install.config_url={{ .Scheme }}://{{ .ServerUrl }}:{{ .DefaultPort }}/config/{{ .UUID }}/{{ .Token }} {{ .BootArguments }}" ,"operating_system":{"slug":"{{ .Slug }}"}}}`
 
 	metadataTmpl := template.Must(template.New("MetData").Parse(metaDataStruct))
 
@@ -139,3 +155,26 @@ func generateMetaData(regoReq *nodev1alpha1.Register, serverURL string) (metadat
 	metadata = output.String()
 	return metadata, nil
 }
+
+// HardwareEqual checks if the hardware in tink matches the desired hardware. The version
+// is ignored as it is managed by tink, and metadata is compared as json since tink
+// does not preserve the formatting
+func HardwareEqual(desired, current *hardware.Hardware) bool {
+	if desired == nil || current == nil {
+		return desired == current
+	}
+
+	if desired.Id != current.Id || !proto.Equal(desired.Network, current.Network) {
+		return false
+	}
+
+	var desiredMetadata, currentMetadata interface{}
+	if err := json.Unmarshal([]byte(desired.Metadata), &desiredMetadata); err != nil {
+		return desired.Metadata == current.Metadata
+	}
+	if err := json.Unmarshal([]byte(current.Metadata), &currentMetadata); err != nil {
+		return false
+	}
+
+	return reflect.DeepEqual(desiredMetadata, currentMetadata)
+}
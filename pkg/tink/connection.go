@@ -0,0 +1,188 @@
+package tink
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/metrics"
+	"github.com/pkg/errors"
+	hw "github.com/tinkerbell/tink/client"
+	"github.com/tinkerbell/tink/protos/hardware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var ErrNotConnected = errors.New("not connected to tink")
+
+// Connection manages the grpc connection to tink. It connects using the endpoints in the
+// tink configmap, reconnects when they change, and retries until tink is reachable, so the
+// operator can start while tink is unavailable. Only the tink configmap is watched, rather
+// than caching every configmap in the cluster
+type Connection struct {
+	// APIReader reads the tink configmap, and KubeClient watches it
+	APIReader  client.Reader
+	KubeClient kubernetes.Interface
+	Log        logr.Logger
+	// RetryInterval is how often the connection is checked and re-established
+	RetryInterval time.Duration
+
+	// watched is the configmap being watched, which is stopped by closing watchStop
+	watched   string
+	watchStop chan struct{}
+
+	mu         sync.RWMutex
+	conn       *grpc.ClientConn
+	fullClient *hw.FullClient
+	connOpts   hw.ConnOptions
+	err        error
+}
+
+// Start implements manager.Runnable
+func (c *Connection) Start(stop <-chan struct{}) error {
+	wait.Until(func() {
+		c.watchConfigMap()
+		c.connect()
+	}, c.RetryInterval, stop)
+
+	if c.watchStop != nil {
+		close(c.watchStop)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	return nil
+}
+
+// watchConfigMap watches the tink configmap, so endpoint changes reconnect right away. The
+// watch is restarted when the configmap is changed in the operator config
+func (c *Connection) watchConfigMap() {
+	cfg := config.Get()
+	watched := cfg.TinkConfigMapNamespace + "/" + cfg.TinkConfigMapName
+	if watched == c.watched {
+		return
+	}
+
+	if c.watchStop != nil {
+		close(c.watchStop)
+	}
+	c.watched = watched
+	c.watchStop = make(chan struct{})
+
+	listWatch := toolscache.NewListWatchFromClient(c.KubeClient.CoreV1().RESTClient(), "configmaps",
+		cfg.TinkConfigMapNamespace, fields.OneTermEqualSelector("metadata.name", cfg.TinkConfigMapName))
+	informer := toolscache.NewSharedInformer(listWatch, &corev1.ConfigMap{}, 0)
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: func(interface{}) {
+			c.connect()
+		},
+		UpdateFunc: func(_, _ interface{}) {
+			c.connect()
+		},
+	})
+
+	go informer.Run(c.watchStop)
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The config
+// server also uses the connection, and runs on all replicas
+func (c *Connection) NeedLeaderElection() bool {
+	return false
+}
+
+// HardwareClient returns the tink hardware client, or ErrNotConnected if
+// tink has not been connected to yet
+func (c *Connection) HardwareClient() (hardware.HardwareServiceClient, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.fullClient == nil {
+		if c.err != nil {
+			return nil, errors.Wrap(c.err, ErrNotConnected.Error())
+		}
+		return nil, ErrNotConnected
+	}
+	return c.fullClient.HardwareClient, nil
+}
+
+// Healthy reports if the connection to tink is usable
+func (c *Connection) Healthy() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.conn == nil {
+		if c.err != nil {
+			return errors.Wrap(c.err, ErrNotConnected.Error())
+		}
+		return ErrNotConnected
+	}
+
+	switch state := c.conn.GetState(); state {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return fmt.Errorf("tink connection is %s", state.String())
+	}
+	return nil
+}
+
+// ReadyCheck implements healthz.Checker, and fails when tink is not connected
+func (c *Connection) ReadyCheck(_ *http.Request) error {
+	return c.Healthy()
+}
+
+// connect establishes a new connection if there is none, or the tink endpoints have changed
+func (c *Connection) connect() {
+	connOpts, err := ConnOptions(c.APIReader)
+	if err != nil {
+		c.Log.Error(err, "unable to read tink endpoints")
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		c.updateMetrics()
+		return
+	}
+
+	c.mu.RLock()
+	unchanged := c.conn != nil && c.connOpts == *connOpts
+	c.mu.RUnlock()
+	if unchanged {
+		c.updateMetrics()
+		return
+	}
+
+	conn, err := hw.NewClientConn(connOpts)
+	c.mu.Lock()
+	if err != nil {
+		c.err = err
+		c.mu.Unlock()
+		c.Log.Error(err, "unable to connect to tink", "certURL", connOpts.CertURL, "grpcAuthority", connOpts.GRPCAuthority)
+		c.updateMetrics()
+		return
+	}
+
+	previous := c.conn
+	c.conn = conn
+	c.fullClient = hw.NewFullClient(conn)
+	c.connOpts = *connOpts
+	c.err = nil
+	c.mu.Unlock()
+
+	if previous != nil {
+		_ = previous.Close()
+	}
+	c.Log.Info("connected to tink", "certURL", connOpts.CertURL, "grpcAuthority", connOpts.GRPCAuthority)
+	c.updateMetrics()
+}
+
+func (c *Connection) updateMetrics() {
+	metrics.SetTinkConnected(c.Healthy() == nil)
+}
@@ -0,0 +1,208 @@
+package util
+
+import (
+	"fmt"
+	"net"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/installer"
+)
+
+// ValidateStaticAddress checks that the address, netmask and gateway are either
+// all set or all empty, and that the address and gateway share a subnet.
+func ValidateStaticAddress(address, netmask, gateway string) (static bool, err error) {
+	if len(address) == 0 && len(netmask) == 0 && len(gateway) == 0 {
+		return false, nil
+	}
+
+	if len(address) == 0 || len(netmask) == 0 || len(gateway) == 0 {
+		return false, fmt.Errorf("address, netmask and gateway must all be specified for a static address")
+	}
+
+	ip := net.ParseIP(address).To4()
+	if ip == nil {
+		return false, fmt.Errorf("invalid ipv4 address %s", address)
+	}
+
+	maskIP := net.ParseIP(netmask).To4()
+	if maskIP == nil {
+		return false, fmt.Errorf("invalid netmask %s", netmask)
+	}
+	mask := net.IPMask(maskIP)
+	if ones, bits := mask.Size(); ones == 0 && bits == 0 {
+		return false, fmt.Errorf("netmask %s is not a valid prefix mask", netmask)
+	}
+
+	gw := net.ParseIP(gateway).To4()
+	if gw == nil {
+		return false, fmt.Errorf("invalid gateway %s", gateway)
+	}
+
+	subnet := &net.IPNet{IP: gw.Mask(mask), Mask: mask}
+	if !subnet.Contains(ip) {
+		return false, fmt.Errorf("address %s is not in the gateway subnet %s", address, subnet.String())
+	}
+
+	if ip.Equal(gw) {
+		return false, fmt.Errorf("address %s is the same as the gateway", address)
+	}
+
+	return true, nil
+}
+
+// ManagementInterfaces returns the NICs backing the harvester-mgmt network. The
+// managementNetwork interfaces are used when specified, else the interface and
+// macAddress fields are used
+func ManagementInterfaces(spec *nodev1alpha1.RegisterSpec) []installer.NetworkInterface {
+	if spec.ManagementNetwork != nil && len(spec.ManagementNetwork.Interfaces) != 0 {
+		return spec.ManagementNetwork.Interfaces
+	}
+
+	return []installer.NetworkInterface{
+		{
+			Name:   spec.Interface,
+			HwAddr: spec.MacAddress,
+		},
+	}
+}
+
+// HardwareAddrs returns the normalised mac addresses used to pxe boot a Register
+func HardwareAddrs(spec *nodev1alpha1.RegisterSpec) (macs map[string]bool, err error) {
+	macs = make(map[string]bool)
+	addrs := []string{spec.MacAddress}
+	for _, nic := range ManagementInterfaces(spec) {
+		addrs = append(addrs, nic.HwAddr)
+	}
+
+	for _, addr := range addrs {
+		hwAddr, err := net.ParseMAC(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mac address %s: %v", addr, err)
+		}
+		macs[hwAddr.String()] = true
+	}
+
+	return macs, nil
+}
+
+// ManagementBondOptions generates the bond options for the harvester-mgmt network.
+// The bondMode is always written in to the mode option, and defaults to active-backup
+func ManagementBondOptions(spec *nodev1alpha1.RegisterSpec) (bondOptions map[string]string, err error) {
+	bondOptions = map[string]string{
+		"mode":   installer.BondModeActiveBackup,
+		"miimon": "100",
+	}
+
+	if spec.ManagementNetwork == nil {
+		return bondOptions, nil
+	}
+
+	for k, v := range spec.ManagementNetwork.BondOptions {
+		bondOptions[k] = v
+	}
+
+	if len(spec.ManagementNetwork.BondMode) != 0 {
+		bondOptions["mode"] = spec.ManagementNetwork.BondMode
+	}
+
+	if !installer.ValidBondMode(bondOptions["mode"]) {
+		return nil, fmt.Errorf("invalid bond mode %s", bondOptions["mode"])
+	}
+
+	return bondOptions, nil
+}
+
+// GenerateNetworks generates the installer networks for a Register. Networks in the
+// spec are used as is, and the harvester-mgmt network is generated from the
+// address and management network fields unless explicitly specified
+func GenerateNetworks(spec *nodev1alpha1.RegisterSpec) (networks map[string]installer.Network, err error) {
+	networks = make(map[string]installer.Network)
+	defaultRouteClaimed := false
+	for name, network := range spec.Networks {
+		networks[name] = installer.Network(network)
+		if network.DefaultRoute {
+			defaultRouteClaimed = true
+		}
+	}
+
+	if _, ok := networks[installer.ManagementNetworkName]; !ok {
+		bondOptions, err := ManagementBondOptions(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		network := installer.Network{
+			Interfaces:   ManagementInterfaces(spec),
+			BondOptions:  bondOptions,
+			DefaultRoute: !defaultRouteClaimed,
+		}
+
+		static, err := ValidateStaticAddress(spec.Address, spec.Netmask, spec.Gateway)
+		if err != nil {
+			return nil, err
+		}
+
+		if static {
+			network.Method = installer.NetworkMethodStatic
+			network.IP = spec.Address
+			network.SubnetMask = spec.Netmask
+			network.Gateway = spec.Gateway
+		} else {
+			network.Method = installer.NetworkMethodDHCP
+		}
+
+		networks[installer.ManagementNetworkName] = network
+	}
+
+	if err := ValidateNetworks(networks); err != nil {
+		return nil, err
+	}
+
+	return networks, nil
+}
+
+// ValidateNetworks checks the individual networks are valid, and that exactly one
+// network claims the default route
+func ValidateNetworks(networks map[string]installer.Network) error {
+	var defaultRoutes []string
+	for name, network := range networks {
+		if network.DefaultRoute {
+			defaultRoutes = append(defaultRoutes, name)
+		}
+
+		switch network.Method {
+		case installer.NetworkMethodDHCP, installer.NetworkMethodNone:
+		case installer.NetworkMethodStatic:
+			if len(network.IP) == 0 || len(network.SubnetMask) == 0 {
+				return fmt.Errorf("network %s: ip and subnetMask are needed for static method", name)
+			}
+			if len(network.Gateway) != 0 {
+				if _, err := ValidateStaticAddress(network.IP, network.SubnetMask, network.Gateway); err != nil {
+					return fmt.Errorf("network %s: %v", name, err)
+				}
+			} else if net.ParseIP(network.IP).To4() == nil {
+				return fmt.Errorf("network %s: invalid ipv4 address %s", name, network.IP)
+			}
+		default:
+			return fmt.Errorf("network %s: invalid method %s", name, network.Method)
+		}
+
+		if network.VlanID < 0 || network.VlanID > 4094 {
+			return fmt.Errorf("network %s: vlanId %d is not between 0 and 4094", name, network.VlanID)
+		}
+
+		if network.MTU != 0 && (network.MTU < 576 || network.MTU > 9216) {
+			return fmt.Errorf("network %s: mtu %d is not between 576 and 9216", name, network.MTU)
+		}
+
+		if mode, ok := network.BondOptions["mode"]; ok && !installer.ValidBondMode(mode) {
+			return fmt.Errorf("network %s: invalid bond mode %s", name, mode)
+		}
+	}
+
+	if len(defaultRoutes) != 1 {
+		return fmt.Errorf("exactly one network must claim the default route, found %d %v", len(defaultRoutes), defaultRoutes)
+	}
+
+	return nil
+}
@@ -0,0 +1,372 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/installer"
+)
+
+func TestValidateStaticAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		address    string
+		netmask    string
+		gateway    string
+		wantStatic bool
+		wantErr    bool
+	}{
+		{
+			name: "dhcp",
+		},
+		{
+			name:       "static",
+			address:    "172.16.128.11",
+			netmask:    "255.255.248.0",
+			gateway:    "172.16.128.1",
+			wantStatic: true,
+		},
+		{
+			name:    "address only",
+			address: "172.16.128.11",
+			wantErr: true,
+		},
+		{
+			name:    "missing gateway",
+			address: "172.16.128.11",
+			netmask: "255.255.248.0",
+			wantErr: true,
+		},
+		{
+			name:    "missing address",
+			netmask: "255.255.248.0",
+			gateway: "172.16.128.1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid address",
+			address: "172.16.128",
+			netmask: "255.255.248.0",
+			gateway: "172.16.128.1",
+			wantErr: true,
+		},
+		{
+			name:    "ipv6 address",
+			address: "fd00::11",
+			netmask: "255.255.248.0",
+			gateway: "172.16.128.1",
+			wantErr: true,
+		},
+		{
+			name:    "address outside the gateway subnet",
+			address: "172.16.136.11",
+			netmask: "255.255.248.0",
+			gateway: "172.16.128.1",
+			wantErr: true,
+		},
+		{
+			name:    "non prefix netmask",
+			address: "172.16.128.11",
+			netmask: "255.0.255.0",
+			gateway: "172.16.128.1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid gateway",
+			address: "172.16.128.11",
+			netmask: "255.255.248.0",
+			gateway: "gateway",
+			wantErr: true,
+		},
+		{
+			name:    "address equal to the gateway",
+			address: "172.16.128.1",
+			netmask: "255.255.248.0",
+			gateway: "172.16.128.1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			static, err := ValidateStaticAddress(tt.address, tt.netmask, tt.gateway)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateStaticAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if static != tt.wantStatic {
+				t.Errorf("ValidateStaticAddress() static = %v, want %v", static, tt.wantStatic)
+			}
+		})
+	}
+}
+
+func TestGenerateNetworks(t *testing.T) {
+	defaultBondOptions := map[string]string{"mode": installer.BondModeActiveBackup, "miimon": "100"}
+
+	tests := []struct {
+		name string
+		spec nodev1alpha1.RegisterSpec
+		// wantMgmt is the expected harvester-mgmt network
+		wantMgmt installer.Network
+		wantErr  bool
+	}{
+		{
+			name: "dhcp",
+			spec: nodev1alpha1.RegisterSpec{
+				Interface:  "eth0",
+				MacAddress: "0c:c4:7a:6b:84:20",
+			},
+			wantMgmt: installer.Network{
+				Interfaces:   []installer.NetworkInterface{{Name: "eth0", HwAddr: "0c:c4:7a:6b:84:20"}},
+				Method:       installer.NetworkMethodDHCP,
+				DefaultRoute: true,
+				BondOptions:  defaultBondOptions,
+			},
+		},
+		{
+			name: "static",
+			spec: nodev1alpha1.RegisterSpec{
+				Interface:  "eth0",
+				MacAddress: "0c:c4:7a:6b:84:20",
+				Address:    "172.16.128.11",
+				Netmask:    "255.255.248.0",
+				Gateway:    "172.16.128.1",
+			},
+			wantMgmt: installer.Network{
+				Interfaces:   []installer.NetworkInterface{{Name: "eth0", HwAddr: "0c:c4:7a:6b:84:20"}},
+				Method:       installer.NetworkMethodStatic,
+				IP:           "172.16.128.11",
+				SubnetMask:   "255.255.248.0",
+				Gateway:      "172.16.128.1",
+				DefaultRoute: true,
+				BondOptions:  defaultBondOptions,
+			},
+		},
+		{
+			name: "invalid static address",
+			spec: nodev1alpha1.RegisterSpec{
+				Interface:  "eth0",
+				MacAddress: "0c:c4:7a:6b:84:20",
+				Address:    "172.16.128.11",
+			},
+			wantErr: true,
+		},
+		{
+			name: "default route claimed by another network",
+			spec: nodev1alpha1.RegisterSpec{
+				Interface:  "eth0",
+				MacAddress: "0c:c4:7a:6b:84:20",
+				Networks: map[string]nodev1alpha1.Network{
+					"storage": {
+						Interfaces:   []installer.NetworkInterface{{Name: "eth1"}},
+						Method:       installer.NetworkMethodDHCP,
+						DefaultRoute: true,
+					},
+				},
+			},
+			wantMgmt: installer.Network{
+				Interfaces:  []installer.NetworkInterface{{Name: "eth0", HwAddr: "0c:c4:7a:6b:84:20"}},
+				Method:      installer.NetworkMethodDHCP,
+				BondOptions: defaultBondOptions,
+			},
+		},
+		{
+			name: "management network",
+			spec: nodev1alpha1.RegisterSpec{
+				MacAddress: "0c:c4:7a:6b:84:20",
+				ManagementNetwork: &nodev1alpha1.ManagementNetwork{
+					Interfaces: []installer.NetworkInterface{
+						{Name: "eth0", HwAddr: "0c:c4:7a:6b:84:20"},
+						{Name: "eth1", HwAddr: "0c:c4:7a:6b:84:21"},
+					},
+					BondMode: installer.BondModeBalanceALB,
+				},
+			},
+			wantMgmt: installer.Network{
+				Interfaces: []installer.NetworkInterface{
+					{Name: "eth0", HwAddr: "0c:c4:7a:6b:84:20"},
+					{Name: "eth1", HwAddr: "0c:c4:7a:6b:84:21"},
+				},
+				Method:       installer.NetworkMethodDHCP,
+				DefaultRoute: true,
+				BondOptions:  map[string]string{"mode": installer.BondModeBalanceALB, "miimon": "100"},
+			},
+		},
+		{
+			name: "harvester-mgmt override",
+			spec: nodev1alpha1.RegisterSpec{
+				Interface:  "eth0",
+				MacAddress: "0c:c4:7a:6b:84:20",
+				Address:    "172.16.128.11",
+				Netmask:    "255.255.248.0",
+				Gateway:    "172.16.128.1",
+				Networks: map[string]nodev1alpha1.Network{
+					installer.ManagementNetworkName: {
+						Interfaces:   []installer.NetworkInterface{{Name: "eth1"}},
+						Method:       installer.NetworkMethodDHCP,
+						DefaultRoute: true,
+					},
+				},
+			},
+			wantMgmt: installer.Network{
+				Interfaces:   []installer.NetworkInterface{{Name: "eth1"}},
+				Method:       installer.NetworkMethodDHCP,
+				DefaultRoute: true,
+			},
+		},
+		{
+			name: "harvester-mgmt override without a default route",
+			spec: nodev1alpha1.RegisterSpec{
+				Interface:  "eth0",
+				MacAddress: "0c:c4:7a:6b:84:20",
+				Networks: map[string]nodev1alpha1.Network{
+					installer.ManagementNetworkName: {
+						Interfaces: []installer.NetworkInterface{{Name: "eth1"}},
+						Method:     installer.NetworkMethodDHCP,
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			networks, err := GenerateNetworks(&tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GenerateNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if mgmt := networks[installer.ManagementNetworkName]; !reflect.DeepEqual(mgmt, tt.wantMgmt) {
+				t.Errorf("GenerateNetworks() harvester-mgmt = %+v, want %+v", mgmt, tt.wantMgmt)
+			}
+		})
+	}
+}
+
+func TestValidateNetworks(t *testing.T) {
+	mgmt := installer.Network{
+		Interfaces:   []installer.NetworkInterface{{Name: "eth0"}},
+		Method:       installer.NetworkMethodDHCP,
+		DefaultRoute: true,
+	}
+
+	tests := []struct {
+		name     string
+		networks map[string]installer.Network
+		wantErr  bool
+	}{
+		{
+			name: "one default route",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: mgmt,
+				"storage": {
+					Interfaces: []installer.NetworkInterface{{Name: "eth1"}},
+					Method:     installer.NetworkMethodStatic,
+					IP:         "10.0.0.11",
+					SubnetMask: "255.255.255.0",
+				},
+			},
+		},
+		{
+			name: "no default route",
+			networks: map[string]installer.Network{
+				"storage": {
+					Interfaces: []installer.NetworkInterface{{Name: "eth1"}},
+					Method:     installer.NetworkMethodDHCP,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "two default routes",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: mgmt,
+				"storage": {
+					Interfaces:   []installer.NetworkInterface{{Name: "eth1"}},
+					Method:       installer.NetworkMethodDHCP,
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "static without ip",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: {
+					Method:       installer.NetworkMethodStatic,
+					SubnetMask:   "255.255.255.0",
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "static address outside the gateway subnet",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: {
+					Method:       installer.NetworkMethodStatic,
+					IP:           "10.0.1.11",
+					SubnetMask:   "255.255.255.0",
+					Gateway:      "10.0.0.1",
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid method",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: {
+					Method:       "manual",
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid vlan",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: {
+					Method:       installer.NetworkMethodDHCP,
+					VlanID:       4095,
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid mtu",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: {
+					Method:       installer.NetworkMethodDHCP,
+					MTU:          100,
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid bond mode",
+			networks: map[string]installer.Network{
+				installer.ManagementNetworkName: {
+					Method:       installer.NetworkMethodDHCP,
+					BondOptions:  map[string]string{"mode": "mirror"},
+					DefaultRoute: true,
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateNetworks(tt.networks); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateNetworks() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
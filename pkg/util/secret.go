@@ -0,0 +1,62 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// passwordHashRegexp matches the crypt(3) formats understood by the harvester installer
+var passwordHashRegexp = regexp.MustCompile(`^\$(1|2[abxy]|5|6|y)\$[^$]+(\$[^$]+)*$`)
+
+// IsPasswordHash checks if a password is already hashed. Passwords starting with $ are
+// treated as hashes by the harvester installer
+func IsPasswordHash(password string) bool {
+	return strings.HasPrefix(password, "$")
+}
+
+// ValidatePassword ensures a pre-hashed password is in a supported crypt format
+func ValidatePassword(password string) error {
+	if IsPasswordHash(password) && !passwordHashRegexp.MatchString(password) {
+		return fmt.Errorf("password starts with $ but is not a valid crypt hash")
+	}
+	return nil
+}
+
+// ValidateSecretRef ensures all fields of a secret reference are specified, and that the secret
+// is in a namespace Registers can reference secrets in
+func ValidateSecretRef(field string, ref *nodev1alpha1.SecretKeyReference) error {
+	if ref == nil {
+		return nil
+	}
+	if len(ref.Name) == 0 || len(ref.Namespace) == 0 || len(ref.Key) == 0 {
+		return fmt.Errorf("%s requires name, namespace and key", field)
+	}
+	if !config.Get().SecretRefAllowed(ref.Namespace) {
+		return fmt.Errorf("%s can not reference secrets in namespace %s, which is not the operator namespace or in secretRefNamespaces", field, ref.Namespace)
+	}
+	return nil
+}
+
+// GetSecretValue returns the value of the key referenced by a secret reference
+func GetSecretValue(reader client.Reader, ref *nodev1alpha1.SecretKeyReference) (value string, err error) {
+	secret := &corev1.Secret{}
+	err = reader.Get(context.TODO(), types.NamespacedName{Name: ref.Name, Namespace: ref.Namespace}, secret)
+	if err != nil {
+		return value, fmt.Errorf("error fetching secret %s/%s: %v", ref.Namespace, ref.Name, err)
+	}
+
+	data, ok := secret.Data[ref.Key]
+	if !ok || len(data) == 0 {
+		return value, fmt.Errorf("key %s not found in secret %s/%s", ref.Key, ref.Namespace, ref.Name)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
 	corev1 "k8s.io/api/core/v1"
 	"net/http"
 	"os"
@@ -63,20 +63,55 @@ func ReturnHTTPRaw(w http.ResponseWriter, r *http.Request, content string) {
 
 // helper to find registration url //
 func FetchServerURL(client client.Client) (url string, err error) {
+	cfg := config.Get()
 	namespace := os.Getenv("namespace")
 	service := &corev1.Service{}
-	err = client.Get(context.TODO(), types.NamespacedName{Name: "harvester-tink-operator", Namespace: namespace}, service)
+	err = client.Get(context.TODO(), types.NamespacedName{Name: cfg.ServiceName, Namespace: namespace}, service)
 	if err != nil {
 		return url, err
 	}
 
 	address := os.Getenv("PUBLIC_IP")
 
-	url = fmt.Sprintf("http://%s:%s", address, nodev1alpha1.DefaultConfigURLPort)
+	url = fmt.Sprintf("http://%s:%s", address, cfg.ConfigURLPort)
 
 	return url, nil
 }
 
+// helper to find the url nodes use to fetch their config. The config server address overrides
+// the node ip, as the address needs to match the config server certificate when using tls
+func ConfigServerURL(client client.Client, tls bool) (url string, err error) {
+	url, err = FetchServerURL(client)
+	if err != nil {
+		return url, err
+	}
+
+	cfg := config.Get()
+	address := cfg.ConfigServerAddress
+	if len(address) == 0 {
+		address = os.Getenv("PUBLIC_IP")
+	}
+
+	if tls {
+		return fmt.Sprintf("https://%s:%s", address, cfg.ConfigTLSPort), nil
+	}
+	return fmt.Sprintf("http://%s:%s", address, cfg.ConfigURLPort), nil
+}
+
+// helper to find the url nodes join the cluster with //
+func JoinServerURL(client client.Client) (url string, err error) {
+	cfg := config.Get()
+	if len(cfg.JoinServerURL) != 0 {
+		return cfg.JoinServerURL, nil
+	}
+
+	if _, err = FetchServerURL(client); err != nil {
+		return url, err
+	}
+
+	return fmt.Sprintf("https://%s:%s", os.Getenv("PUBLIC_IP"), cfg.JoinPort), nil
+}
+
 // helper to find harvester version
 func FindHarvesterVersion(client client.Client) (version string, err error) {
 	versionObj := &unstructured.Unstructured{
@@ -94,3 +129,14 @@ func FindHarvesterVersion(client client.Client) (version string, err error) {
 	version = versionObj.Object["value"].(string)
 	return version, err
 }
+
+// helper to find the join token of the harvester cluster
+func FindClusterToken(reader client.Reader) (token string, err error) {
+	ref := config.Get().JoinTokenSecret
+	return GetSecretValue(reader, &ref)
+}
+
+// helper to generate the iso url for a harvester version
+func GenerateISOURL(version string) string {
+	return config.Get().ISOURL(version)
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/config"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	DefaultPath = "/mutate-node-harvesterci-io-v1alpha1-register"
+)
+
+// +kubebuilder:webhook:path=/mutate-node-harvesterci-io-v1alpha1-register,mutating=true,failurePolicy=fail,groups=node.harvesterci.io,resources=registers,verbs=create;update,versions=v1alpha1,name=mregister.harvesterci.io
+
+// RegisterDefaulter writes the operator defaults in to the Register spec, so the
+// object reflects what will be installed and is not affected by later changes
+// to the operator defaults
+type RegisterDefaulter struct {
+	Client  client.Client
+	Log     logr.Logger
+	decoder *admission.Decoder
+}
+
+func (d *RegisterDefaulter) Handle(ctx context.Context, req admission.Request) admission.Response {
+	regoReq := &nodev1alpha1.Register{}
+	if err := d.decoder.Decode(req, regoReq); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	if !regoReq.DeletionTimestamp.IsZero() {
+		return admission.Allowed("")
+	}
+
+	d.setDefaults(regoReq)
+
+	marshalledRegoReq, err := json.Marshal(regoReq)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshalledRegoReq)
+}
+
+// InjectDecoder is called by the webhook server to inject the admission decoder
+func (d *RegisterDefaulter) InjectDecoder(decoder *admission.Decoder) error {
+	d.decoder = decoder
+	return nil
+}
+
+func (d *RegisterDefaulter) setDefaults(regoReq *nodev1alpha1.Register) {
+	cfg := config.Get()
+	if len(regoReq.Spec.Slug) == 0 {
+		regoReq.Spec.Slug = cfg.DefaultSlug
+	}
+
+	if len(regoReq.Spec.Disk) == 0 {
+		regoReq.Spec.Disk = cfg.DefaultDisk
+	}
+
+	if len(regoReq.Spec.PXEIsoURL) == 0 {
+		version, err := util.FindHarvesterVersion(d.Client)
+		if err != nil {
+			// config server will derive the iso url when the config is served
+			d.Log.Error(err, "unable to find harvester version, skipping pxeIsoURL default", "name", regoReq.Name)
+			return
+		}
+		regoReq.Spec.PXEIsoURL = util.GenerateISOURL(version)
+	}
+}
@@ -0,0 +1,218 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/util"
+	"k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+const (
+	ValidatePath = "/validate-node-harvesterci-io-v1alpha1-register"
+)
+
+// +kubebuilder:webhook:path=/validate-node-harvesterci-io-v1alpha1-register,mutating=false,failurePolicy=fail,groups=node.harvesterci.io,resources=registers,verbs=create;update,versions=v1alpha1,name=vregister.harvesterci.io
+
+// RegisterValidator validates Register objects before they are persisted, to
+// avoid errors only surfacing as failed pxe boots
+type RegisterValidator struct {
+	Client  client.Client
+	Log     logr.Logger
+	decoder *admission.Decoder
+}
+
+func (v *RegisterValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	regoReq := &nodev1alpha1.Register{}
+	if err := v.decoder.Decode(req, regoReq); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	// the controller updates labels and finalizers, which must not be blocked by
+	// registers predating a validation rule, so only spec changes are validated
+	specChanged := true
+	if req.Operation == v1beta1.Update {
+		oldRegoReq := &nodev1alpha1.Register{}
+		if err := v.decoder.DecodeRaw(req.OldObject, oldRegoReq); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		if err := validateUpdate(oldRegoReq, regoReq); err != nil {
+			v.Log.Info("rejecting register update", "name", regoReq.Name, "reason", err.Error())
+			return admission.Denied(err.Error())
+		}
+		specChanged = !reflect.DeepEqual(oldRegoReq.Spec, regoReq.Spec)
+	}
+
+	if specChanged {
+		if err := v.validate(ctx, regoReq); err != nil {
+			v.Log.Info("rejecting register", "name", regoReq.Name, "reason", err.Error())
+			return admission.Denied(err.Error())
+		}
+	}
+
+	return admission.Allowed("")
+}
+
+// InjectDecoder is called by the webhook server to inject the admission decoder
+func (v *RegisterValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+func (v *RegisterValidator) validate(ctx context.Context, regoReq *nodev1alpha1.Register) error {
+	// registers being deleted only have their finalizers updated
+	if !regoReq.DeletionTimestamp.IsZero() {
+		return nil
+	}
+
+	macs, err := util.HardwareAddrs(&regoReq.Spec)
+	if err != nil {
+		return err
+	}
+
+	if err := validateMacAddress(&regoReq.Spec); err != nil {
+		return err
+	}
+
+	if _, err := util.GenerateNetworks(&regoReq.Spec); err != nil {
+		return err
+	}
+
+	if len(regoReq.Spec.Slug) != 0 && !containsString(nodev1alpha1.SupportedSlugs, regoReq.Spec.Slug) {
+		return fmt.Errorf("unknown slug %s, supported slugs are %v", regoReq.Spec.Slug, nodev1alpha1.SupportedSlugs)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(regoReq.Hostname()); len(errs) != 0 {
+		return fmt.Errorf("hostname %s is not a valid node name: %v", regoReq.Hostname(), errs)
+	}
+
+	if len(regoReq.Spec.Disk) != 0 && !filepath.IsAbs(regoReq.Spec.Disk) {
+		return fmt.Errorf("disk %s is not an absolute path", regoReq.Spec.Disk)
+	}
+
+	if timeout := regoReq.Spec.ProvisioningTimeout; timeout != nil && timeout.Duration < 0 {
+		return fmt.Errorf("provisioningTimeout %s can not be negative", timeout.Duration)
+	}
+
+	if err := validateCredentials(&regoReq.Spec); err != nil {
+		return err
+	}
+
+	registerList := &nodev1alpha1.RegisterList{}
+	if err := v.Client.List(ctx, registerList); err != nil {
+		return fmt.Errorf("error listing registers: %v", err)
+	}
+
+	for _, register := range registerList.Items {
+		if register.Name == regoReq.Name {
+			continue
+		}
+
+		existingMacs, err := util.HardwareAddrs(&register.Spec)
+		if err != nil {
+			// existing objects may predate the webhook, so ignore the ones which dont parse
+			continue
+		}
+
+		for mac := range macs {
+			if existingMacs[mac] {
+				return fmt.Errorf("mac address %s is already in use by register %s", mac, register.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateMacAddress ensures macAddress is one of the managementNetwork interfaces when they are
+// specified, as only those interfaces are pushed to tink
+func validateMacAddress(spec *nodev1alpha1.RegisterSpec) error {
+	if spec.ManagementNetwork == nil || len(spec.ManagementNetwork.Interfaces) == 0 {
+		return nil
+	}
+
+	macAddress, err := net.ParseMAC(spec.MacAddress)
+	if err != nil {
+		return fmt.Errorf("invalid mac address %s: %v", spec.MacAddress, err)
+	}
+
+	for _, nic := range spec.ManagementNetwork.Interfaces {
+		if hwAddr, err := net.ParseMAC(nic.HwAddr); err == nil && hwAddr.String() == macAddress.String() {
+			return nil
+		}
+	}
+	return fmt.Errorf("macAddress %s must be one of the managementNetwork interfaces", spec.MacAddress)
+}
+
+// validateCredentials checks the token and password fields. The referenced secrets
+// are only resolved when the config is served, as they may be created later
+func validateCredentials(spec *nodev1alpha1.RegisterSpec) error {
+	if len(spec.Token) != 0 && spec.TokenSecretRef != nil {
+		return fmt.Errorf("only one of token or tokenSecretRef can be specified")
+	}
+
+	if len(spec.Password) != 0 && spec.PasswordSecretRef != nil {
+		return fmt.Errorf("only one of password or passwordSecretRef can be specified")
+	}
+
+	if err := util.ValidateSecretRef("tokenSecretRef", spec.TokenSecretRef); err != nil {
+		return err
+	}
+
+	if err := util.ValidateSecretRef("passwordSecretRef", spec.PasswordSecretRef); err != nil {
+		return err
+	}
+
+	return util.ValidatePassword(spec.Password)
+}
+
+// validateUpdate ensures fields used to identify the hardware in tink are
+// not changed once the hardware has been pushed
+func validateUpdate(oldRegoReq, regoReq *nodev1alpha1.Register) error {
+	if oldUUID, ok := oldRegoReq.Labels["uuid"]; ok && len(oldRegoReq.Status.UUID) != 0 {
+		if regoReq.Labels["uuid"] != oldUUID {
+			return fmt.Errorf("uuid label cannot be changed once allocated")
+		}
+	}
+
+	if regoReq.Spec.ReprovisionGeneration < oldRegoReq.Spec.ReprovisionGeneration {
+		return fmt.Errorf("reprovisionGeneration cannot be decreased")
+	}
+
+	switch oldRegoReq.Status.Status {
+	case nodev1alpha1.HWPushed, nodev1alpha1.NodeProcessed, nodev1alpha1.Failed:
+	default:
+		return nil
+	}
+
+	if oldRegoReq.Spec.MacAddress != regoReq.Spec.MacAddress {
+		return fmt.Errorf("macAddress cannot be changed once hardware has been pushed to tink")
+	}
+
+	oldMacs, _ := util.HardwareAddrs(&oldRegoReq.Spec)
+	macs, _ := util.HardwareAddrs(&regoReq.Spec)
+	if !reflect.DeepEqual(oldMacs, macs) {
+		return fmt.Errorf("management network interfaces cannot be changed once hardware has been pushed to tink")
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
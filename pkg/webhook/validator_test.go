@@ -0,0 +1,348 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	nodev1alpha1 "github.com/ibrokethecloud/harvester-tink-operator/api/v1alpha1"
+	"github.com/ibrokethecloud/harvester-tink-operator/pkg/installer"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// testRegister generates a valid register, changed by mutate when set
+func testRegister(name, mac string, mutate func(regoReq *nodev1alpha1.Register)) *nodev1alpha1.Register {
+	regoReq := &nodev1alpha1.Register{
+		TypeMeta:   metav1.TypeMeta{APIVersion: nodev1alpha1.GroupVersion.String(), Kind: "Register"},
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: nodev1alpha1.RegisterSpec{
+			MacAddress: mac,
+			Interface:  "eth0",
+			Password:   "changeme",
+		},
+	}
+	if mutate != nil {
+		mutate(regoReq)
+	}
+	return regoReq
+}
+
+func TestHandle(t *testing.T) {
+	if err := nodev1alpha1.AddToScheme(scheme.Scheme); err != nil {
+		t.Fatal(err)
+	}
+	existing := testRegister("node2", "0c:c4:7a:6b:84:21", nil)
+
+	tests := []struct {
+		name        string
+		operation   v1beta1.Operation
+		oldRegoReq  *nodev1alpha1.Register
+		regoReq     *nodev1alpha1.Register
+		wantAllowed bool
+	}{
+		{
+			name:        "create",
+			operation:   v1beta1.Create,
+			regoReq:     testRegister("node1", "0c:c4:7a:6b:84:20", nil),
+			wantAllowed: true,
+		},
+		{
+			name:      "create with a mac address in use",
+			operation: v1beta1.Create,
+			regoReq:   testRegister("node1", "0C-C4-7A-6B-84-21", nil),
+		},
+		{
+			name:      "create with a management interface mac address in use",
+			operation: v1beta1.Create,
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.ManagementNetwork = &nodev1alpha1.ManagementNetwork{
+					Interfaces: []installer.NetworkInterface{
+						{Name: "eth0", HwAddr: "0c:c4:7a:6b:84:20"},
+						{Name: "eth1", HwAddr: "0c:c4:7a:6b:84:21"},
+					},
+				}
+			}),
+		},
+		{
+			name:      "create with a partial static address",
+			operation: v1beta1.Create,
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.Address = "172.16.128.11"
+			}),
+		},
+		{
+			name:      "create with an invalid hostname",
+			operation: v1beta1.Create,
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.Hostname = "Node_1"
+			}),
+		},
+		{
+			name:      "create with a secret ref outside the operator namespace",
+			operation: v1beta1.Create,
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.Password = ""
+				regoReq.Spec.PasswordSecretRef = &nodev1alpha1.SecretKeyReference{Name: "admin", Namespace: "kube-system", Key: "password"}
+			}),
+		},
+		{
+			name:      "update without a spec change skips validation",
+			operation: v1beta1.Update,
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:21", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Status.Status = nodev1alpha1.NodeProcessed
+			}),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:21", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Labels = map[string]string{"nodeReady": "true"}
+				regoReq.Status.Status = nodev1alpha1.NodeProcessed
+			}),
+			wantAllowed: true,
+		},
+		{
+			name:       "update with a spec change is validated",
+			operation:  v1beta1.Update,
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", nil),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.Disk = "sda"
+			}),
+		},
+		{
+			name:       "update with a valid spec change",
+			operation:  v1beta1.Update,
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", nil),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.Disk = "/dev/nvme0n1"
+			}),
+			wantAllowed: true,
+		},
+		{
+			name:       "update without a spec change still checks immutable fields",
+			operation:  v1beta1.Update,
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:21", withUUID("0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e")),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:21", withUUID("f2a3e1a4-7f0c-4f6b-9a64-0d1a7e0c2b57")),
+		},
+		{
+			name:      "delete in progress",
+			operation: v1beta1.Update,
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:21", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Finalizers = []string{"finalizer"}
+			}),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:21", func(regoReq *nodev1alpha1.Register) {
+				now := metav1.Now()
+				regoReq.DeletionTimestamp = &now
+				regoReq.Spec.Disk = "sda"
+			}),
+			wantAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder, err := admission.NewDecoder(scheme.Scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := &RegisterValidator{
+				Client:  fake.NewFakeClientWithScheme(scheme.Scheme, existing.DeepCopy()),
+				Log:     ctrl.Log.WithName("test"),
+				decoder: decoder,
+			}
+
+			req := admission.Request{AdmissionRequest: v1beta1.AdmissionRequest{
+				Operation: tt.operation,
+				Object:    rawRegister(t, tt.regoReq),
+			}}
+			if tt.oldRegoReq != nil {
+				req.OldObject = rawRegister(t, tt.oldRegoReq)
+			}
+
+			resp := v.Handle(context.Background(), req)
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("Handle() allowed = %v, want %v: %v", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+		})
+	}
+}
+
+func TestValidateUpdate(t *testing.T) {
+	mgmtNetwork := func(macs ...string) func(regoReq *nodev1alpha1.Register) {
+		return func(regoReq *nodev1alpha1.Register) {
+			regoReq.Spec.ManagementNetwork = &nodev1alpha1.ManagementNetwork{}
+			for _, mac := range macs {
+				regoReq.Spec.ManagementNetwork.Interfaces = append(regoReq.Spec.ManagementNetwork.Interfaces, installer.NetworkInterface{HwAddr: mac})
+			}
+		}
+	}
+	inPhase := func(phase string, mutate func(regoReq *nodev1alpha1.Register)) func(regoReq *nodev1alpha1.Register) {
+		return func(regoReq *nodev1alpha1.Register) {
+			regoReq.Status.Status = phase
+			if mutate != nil {
+				mutate(regoReq)
+			}
+		}
+	}
+
+	tests := []struct {
+		name       string
+		oldRegoReq *nodev1alpha1.Register
+		regoReq    *nodev1alpha1.Register
+		wantErr    bool
+	}{
+		{
+			name:       "unchanged",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", withUUID("0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e")),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:20", withUUID("0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e")),
+		},
+		{
+			name:       "uuid label changed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", withUUID("0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e")),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:20", withUUID("f2a3e1a4-7f0c-4f6b-9a64-0d1a7e0c2b57")),
+			wantErr:    true,
+		},
+		{
+			name:       "uuid label removed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", withUUID("0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e")),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Status.UUID = "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e"
+			}),
+			wantErr: true,
+		},
+		{
+			name: "uuid label changed before allocation",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Labels = map[string]string{"uuid": "0c4a6e5e-3f5b-4a47-8c0e-6a6b1c6c1a2e"}
+			}),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Labels = map[string]string{"uuid": "f2a3e1a4-7f0c-4f6b-9a64-0d1a7e0c2b57"}
+			}),
+		},
+		{
+			name: "reprovisionGeneration increased",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.ReprovisionGeneration = 1
+			}),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.ReprovisionGeneration = 2
+			}),
+		},
+		{
+			name: "reprovisionGeneration decreased",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.ReprovisionGeneration = 2
+			}),
+			regoReq: testRegister("node1", "0c:c4:7a:6b:84:20", func(regoReq *nodev1alpha1.Register) {
+				regoReq.Spec.ReprovisionGeneration = 1
+			}),
+			wantErr: true,
+		},
+		{
+			name:       "macAddress changed before the hardware is pushed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.UIDGenerated, nil)),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:21", inPhase(nodev1alpha1.UIDGenerated, nil)),
+		},
+		{
+			name:       "macAddress changed once the hardware is pushed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.HWPushed, nil)),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:21", inPhase(nodev1alpha1.HWPushed, nil)),
+			wantErr:    true,
+		},
+		{
+			name:       "macAddress changed once failed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.Failed, nil)),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:21", inPhase(nodev1alpha1.Failed, nil)),
+			wantErr:    true,
+		},
+		{
+			name:       "management interfaces changed once processed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.NodeProcessed, mgmtNetwork("0c:c4:7a:6b:84:20", "0c:c4:7a:6b:84:21"))),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.NodeProcessed, mgmtNetwork("0c:c4:7a:6b:84:20", "0c:c4:7a:6b:84:22"))),
+			wantErr:    true,
+		},
+		{
+			name:       "management interfaces reordered once processed",
+			oldRegoReq: testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.NodeProcessed, mgmtNetwork("0c:c4:7a:6b:84:20", "0c:c4:7a:6b:84:21"))),
+			regoReq:    testRegister("node1", "0c:c4:7a:6b:84:20", inPhase(nodev1alpha1.NodeProcessed, mgmtNetwork("0C:C4:7A:6B:84:21", "0c:c4:7a:6b:84:20"))),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateUpdate(tt.oldRegoReq, tt.regoReq); (err != nil) != tt.wantErr {
+				t.Errorf("validateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMacAddress(t *testing.T) {
+	tests := []struct {
+		name       string
+		macAddress string
+		interfaces []string
+		wantErr    bool
+	}{
+		{
+			name:       "no management network",
+			macAddress: "0c:c4:7a:6b:84:20",
+		},
+		{
+			name:       "one of the interfaces",
+			macAddress: "0c:c4:7a:6b:84:21",
+			interfaces: []string{"0c:c4:7a:6b:84:20", "0c:c4:7a:6b:84:21"},
+		},
+		{
+			name:       "one of the interfaces in another format",
+			macAddress: "0C-C4-7A-6B-84-21",
+			interfaces: []string{"0c:c4:7a:6b:84:20", "0c:c4:7a:6b:84:21"},
+		},
+		{
+			name:       "not one of the interfaces",
+			macAddress: "0c:c4:7a:6b:84:22",
+			interfaces: []string{"0c:c4:7a:6b:84:20", "0c:c4:7a:6b:84:21"},
+			wantErr:    true,
+		},
+		{
+			name:       "invalid mac address",
+			macAddress: "0c:c4:7a:6b:84",
+			interfaces: []string{"0c:c4:7a:6b:84:20"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := &nodev1alpha1.RegisterSpec{MacAddress: tt.macAddress}
+			if len(tt.interfaces) != 0 {
+				spec.ManagementNetwork = &nodev1alpha1.ManagementNetwork{}
+				for _, mac := range tt.interfaces {
+					spec.ManagementNetwork.Interfaces = append(spec.ManagementNetwork.Interfaces, installer.NetworkInterface{HwAddr: mac})
+				}
+			}
+
+			if err := validateMacAddress(spec); (err != nil) != tt.wantErr {
+				t.Errorf("validateMacAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withUUID sets the uuid label and the allocated uuid of a register
+func withUUID(uuid string) func(regoReq *nodev1alpha1.Register) {
+	return func(regoReq *nodev1alpha1.Register) {
+		regoReq.Labels = map[string]string{"uuid": uuid}
+		regoReq.Status.UUID = uuid
+	}
+}
+
+func rawRegister(t *testing.T, regoReq *nodev1alpha1.Register) runtime.RawExtension {
+	raw, err := json.Marshal(regoReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runtime.RawExtension{Raw: raw}
+}